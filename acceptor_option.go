@@ -0,0 +1,181 @@
+package dnet
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const defHandshakeTimeout = 10 * time.Second
+
+type AcceptorOption func(opt *AcceptorOptions)
+
+// loadAcceptorOptions returns an initialized *AcceptorOptions with options
+func loadAcceptorOptions(options ...AcceptorOption) *AcceptorOptions {
+	opts := new(AcceptorOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// AcceptorOptions contains all options which will be applied when instantiating an acceptor.
+type AcceptorOptions struct {
+	// the max number of connections, 0 means no limit.
+	// a connection is counted until it is closed, by OnConnection or by the session built on it.
+	MaxConnections int
+
+	// the max number of connections from one ip, 0 means no limit
+	MaxConnectionsPerIP int
+
+	// the connections from the ips denied by IPFilter are refused with ErrIPDenied.
+	// WSAcceptor takes the client ip from X-Forwarded-For if the peer is a trusted proxy of IPFilter.
+	IPFilter *IPFilter
+
+	// TCPAcceptor serves TLS and WSAcceptor serves wss if it is set,
+	// set ClientAuth and ClientCAs to verify the client certificates.
+	TLSConfig *tls.Config
+
+	// the certificate and key files for TLS, they are loaded into the certificates of TLSConfig by Serve.
+	CertFile string
+	KeyFile  string
+
+	// the deadline for the TLS handshake. default net.defHandshakeTimeout
+	HandshakeTimeout time.Duration
+
+	// the subprotocols supported by WSAcceptor in order of preference,
+	// the first one requested by the client is selected, see WSConn.Subprotocol.
+	Subprotocols []string
+
+	// WSAcceptor negotiates permessage-deflate with the clients if EnableCompression is true,
+	// CompressionLevel is the flate level of the messages written, 0 means default.
+	EnableCompression bool
+	CompressionLevel  int
+
+	// the max size of a message read by WSAcceptor and UDPAcceptor,
+	// 0 means no limit on WSAcceptor, and 4MB of the reliable messages on UDPAcceptor.
+	// a session reading a larger message is closed with ErrMessageTooLarge.
+	MaxMessageSize int64
+
+	// the permission bits of the socket file of UnixAcceptor, 0 means it is left as created by umask.
+	// the socket file is set to SocketMode before it appears at the path.
+	SocketMode os.FileMode
+
+	// WSAcceptor will call the UpgradeCallback before upgrading a request,
+	// it rejects the request with the returned http status if it is not 0.
+	UpgradeCallback func(r *http.Request) (status int)
+
+	// acceptor will call the RefuseCallback before closing a connection refused with ErrTooManyConnections,
+	// such as writing a "server full" message. conn is the raw connection, TLS is not handshaked.
+	// WSAcceptor upgrades the request refused for it, or replies 503 without upgrading if it is nil.
+	// The connections from the ips denied by IPFilter are closed, or replied 403 by WSAcceptor,
+	// without the TLS handshake and the upgrade. UDPAcceptor refuses the handshake without calling it.
+	RefuseCallback func(conn net.Conn, reason error)
+}
+
+func (opt *AcceptorOptions) handshakeTimeout() time.Duration {
+	if opt.HandshakeTimeout > 0 {
+		return opt.HandshakeTimeout
+	}
+	return defHandshakeTimeout
+}
+
+// loadTLSConfig returns the TLS config with the certificate files loaded, or nil if TLS is not set
+func (opt *AcceptorOptions) loadTLSConfig() (*tls.Config, error) {
+	if opt.CertFile == "" && opt.KeyFile == "" {
+		return opt.TLSConfig, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(opt.CertFile, opt.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	if opt.TLSConfig != nil {
+		config = opt.TLSConfig.Clone()
+	}
+	config.Certificates = append(config.Certificates, cert)
+	return config, nil
+}
+
+// WithMaxConnections sets the max number of connections, and of connections from one ip.
+func WithMaxConnections(max, maxPerIP int) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.MaxConnections = max
+		opt.MaxConnectionsPerIP = maxPerIP
+	}
+}
+
+// WithIPFilter sets the ip filter, it can be reloaded at runtime.
+func WithIPFilter(filter *IPFilter) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.IPFilter = filter
+	}
+}
+
+// WithTLSConfig sets the TLS config.
+func WithTLSConfig(config *tls.Config) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.TLSConfig = config
+	}
+}
+
+// WithTLSCertFile sets the certificate and key files for TLS.
+func WithTLSCertFile(certFile, keyFile string) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.CertFile = certFile
+		opt.KeyFile = keyFile
+	}
+}
+
+// WithHandshakeTimeout sets the deadline of the TLS handshake.
+func WithHandshakeTimeout(timeout time.Duration) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.HandshakeTimeout = timeout
+	}
+}
+
+// WithSubprotocols sets the subprotocols supported by WSAcceptor.
+func WithSubprotocols(subprotocols ...string) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.Subprotocols = subprotocols
+	}
+}
+
+// WithCompression enables permessage-deflate with the compression level, 0 means default.
+func WithCompression(level int) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.EnableCompression = true
+		opt.CompressionLevel = level
+	}
+}
+
+// WithMaxMessageSize sets the max size of a message.
+func WithMaxMessageSize(size int64) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.MaxMessageSize = size
+	}
+}
+
+// WithSocketMode sets the permission bits of the socket file, such as 0660.
+func WithSocketMode(mode os.FileMode) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.SocketMode = mode
+	}
+}
+
+// WithUpgradeCallback sets upgrade callback.
+func WithUpgradeCallback(upgradeCallback func(r *http.Request) (status int)) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.UpgradeCallback = upgradeCallback
+	}
+}
+
+// WithRefuseCallback sets refuse callback.
+func WithRefuseCallback(refuseCallback func(conn net.Conn, reason error)) AcceptorOption {
+	return func(opt *AcceptorOptions) {
+		opt.RefuseCallback = refuseCallback
+	}
+}
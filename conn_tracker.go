@@ -0,0 +1,257 @@
+package dnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const shutdownPollInterval = 50 * time.Millisecond
+
+// connTracker tracks the connections accepted by an acceptor and the sessions built on them.
+// A connection is counted until it is closed, by OnConnection or by the session built on it.
+type connTracker struct {
+	opts     *AcceptorOptions
+	lock     sync.Mutex
+	sessions map[trackedSession]struct{}
+	conns    int
+	perIP    map[string]int
+	closing  bool
+}
+
+// trackedSession is a session built on an accepted connection
+type trackedSession interface {
+	Close(reason error)
+	forceClose(reason error)
+}
+
+// connSlot is the count of an accepted connection, it is released when the connection is closed.
+// The sessions built on the connection join the tracker through it, whenever they are built.
+type connSlot struct {
+	tracker  *connTracker
+	ip       string
+	released bool
+}
+
+// trackedConn carries the slot of an accepted connection not defined by dnet, such as *net.TCPConn.
+// The sessions built on it use the wrapped Conn, and release the slot when they are closed.
+type trackedConn struct {
+	net.Conn
+	slot *connSlot
+}
+
+// Close closes the connection and releases its count
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.slot.release()
+	return err
+}
+
+// Unwrap returns the accepted conn, such as *net.TCPConn.
+// Closing it doesn't release the count, close the trackedConn instead.
+func (c *trackedConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// SyscallConn returns the raw connection of the accepted conn
+func (c *trackedConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrNotSyscallConn
+	}
+	return sc.SyscallConn()
+}
+
+func newConnTracker(opts *AcceptorOptions) *connTracker {
+	return &connTracker{
+		opts:     opts,
+		sessions: map[trackedSession]struct{}{},
+		perIP:    map[string]int{},
+	}
+}
+
+// acquire counts a connection from ip.
+// It returns ErrIPDenied if ip is denied by the IPFilter, or ErrTooManyConnections if the limits are reached.
+func (t *connTracker) acquire(ip string) error {
+	if t.opts.IPFilter != nil && !t.opts.IPFilter.Allow(ip) {
+		return ErrIPDenied
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.opts.MaxConnections > 0 && t.conns >= t.opts.MaxConnections {
+		return ErrTooManyConnections
+	}
+	if t.opts.MaxConnectionsPerIP > 0 && t.perIP[ip] >= t.opts.MaxConnectionsPerIP {
+		return ErrTooManyConnections
+	}
+	t.conns++
+	t.perIP[ip]++
+	return nil
+}
+
+func (t *connTracker) release(ip string) {
+	t.lock.Lock()
+	t.conns--
+	if t.perIP[ip]--; t.perIP[ip] <= 0 {
+		delete(t.perIP, ip)
+	}
+	t.lock.Unlock()
+}
+
+// count returns the number of connections, and the number from ip
+func (t *connTracker) count(ip string) (int, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.conns, t.perIP[ip]
+}
+
+// handle invokes handler with the conn acquired from ip, the conn carries its slot.
+func (t *connTracker) handle(conn net.Conn, ip string, handler AcceptorHandler) {
+	slot := &connSlot{tracker: t, ip: ip}
+	handler.OnConnection(slot.attach(conn))
+}
+
+// refuse closes the raw conn refused for reason, RefuseCallback is only called for ErrTooManyConnections.
+func (t *connTracker) refuse(conn net.Conn, reason error) {
+	if t.opts.RefuseCallback != nil && reason == ErrTooManyConnections {
+		// 限制写入的时间
+		_ = conn.SetDeadline(time.Now().Add(t.opts.handshakeTimeout()))
+		t.opts.RefuseCallback(conn, reason)
+	}
+	_ = conn.Close()
+}
+
+// attach makes conn carry the slot, the conns not defined by dnet are wrapped by trackedConn.
+func (c *connSlot) attach(conn net.Conn) net.Conn {
+	switch cc := conn.(type) {
+	case *WSConn:
+		cc.slot = c
+		return cc
+	case *UDPConn:
+		cc.lock.Lock()
+		cc.slot = c
+		done := cc.isDone()
+		cc.lock.Unlock()
+		if done {
+			c.release()
+		}
+		return cc
+	}
+	return &trackedConn{Conn: conn, slot: c}
+}
+
+// slotOf returns the slot carried by an accepted conn, and the conn the session uses.
+func slotOf(conn net.Conn) (*connSlot, net.Conn) {
+	switch c := conn.(type) {
+	case *trackedConn:
+		return c.slot, c.Conn
+	case *WSConn:
+		return c.slot, conn
+	case *UDPConn:
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.slot, conn
+	}
+	return nil, conn
+}
+
+// release releases the count of the connection once
+func (c *connSlot) release() {
+	t := c.tracker
+	t.lock.Lock()
+	released := c.released
+	c.released = true
+	t.lock.Unlock()
+	if !released {
+		t.release(c.ip)
+	}
+}
+
+// add joins the session built on the conn
+func (c *connSlot) add(s trackedSession) {
+	t := c.tracker
+	t.lock.Lock()
+	closing := t.closing
+	if !closing {
+		t.sessions[s] = struct{}{}
+	}
+	t.lock.Unlock()
+
+	if closing {
+		s.Close(ErrAcceptorShutdown)
+	}
+}
+
+// remove removes the closed session, and releases the count of the connection closed with it
+func (c *connSlot) remove(s trackedSession) {
+	t := c.tracker
+	t.lock.Lock()
+	delete(t.sessions, s)
+	t.lock.Unlock()
+	c.release()
+}
+
+// remoteIP returns the ip of addr
+func remoteIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case nil:
+		return ""
+	}
+	return hostIP(addr.String())
+}
+
+// hostIP returns the host of address, or address if it has no port
+func hostIP(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+func (t *connTracker) snapshot() []trackedSession {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sessions := make([]trackedSession, 0, len(t.sessions))
+	for s := range t.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// shutdown closes all sessions with ErrAcceptorShutdown and waits for them to drain their send queues.
+// Sessions still open when ctx is done are force closed with ErrShutdownTimeout.
+func (t *connTracker) shutdown(ctx context.Context) error {
+	t.lock.Lock()
+	t.closing = true
+	t.lock.Unlock()
+
+	for _, s := range t.snapshot() {
+		s.Close(ErrAcceptorShutdown)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		t.lock.Lock()
+		n := len(t.sessions)
+		t.lock.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, s := range t.snapshot() {
+				s.forceClose(ErrShutdownTimeout)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
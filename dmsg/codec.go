@@ -0,0 +1,92 @@
+package dmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// 消息格式: 消息头(消息体长度 + cmd + 消息ID), 消息体
+const (
+	lenSize    = 2
+	cmdSize    = 2
+	idSize     = 2
+	headSize   = lenSize + cmdSize + idSize
+	maxBodyLen = 65535 // 与 lenSize 有关
+)
+
+// Message is the message framed by Codec.
+// Cmd is free for the application, such as the serial number of a request.
+type Message struct {
+	Cmd  uint16
+	ID   uint16
+	Data interface{} // the registered message, nil if ID is unknown by the Registry
+	Body []byte      // the body of a message with the unknown ID
+}
+
+// Codec frames the messages with the header of body length, cmd and message id, it implements dnet.Codec.
+type Codec struct {
+	registry *Registry
+}
+
+// NewCodec returns the codec of the messages registered in registry
+func NewCodec(registry *Registry) *Codec {
+	return &Codec{registry: registry}
+}
+
+// Decode returns a *Message. The message of an unknown id is returned with its Body,
+// so that Router passes it to the unknown handler.
+func (c *Codec) Decode(reader io.Reader) (interface{}, error) {
+	hdr := make([]byte, headSize)
+	if _, err := io.ReadFull(reader, hdr); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint16(hdr)
+	msg := &Message{
+		Cmd: binary.BigEndian.Uint16(hdr[lenSize:]),
+		ID:  binary.BigEndian.Uint16(hdr[lenSize+cmdSize:]),
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	data, err := c.registry.Unmarshal(msg.ID, body)
+	if err != nil {
+		if errors.Is(err, ErrUnknownID) {
+			msg.Body = body
+			return msg, nil
+		}
+		return nil, err
+	}
+	msg.Data = data
+	return msg, nil
+}
+
+// Encode accepts a *Message, or a registered message sent with Cmd 0.
+func (c *Codec) Encode(o interface{}) ([]byte, error) {
+	var cmd uint16
+	data := o
+	if msg, ok := o.(*Message); ok {
+		cmd, data = msg.Cmd, msg.Data
+	}
+	if data == nil {
+		return nil, fmt.Errorf("dmsg:Encode message data is nil")
+	}
+
+	id, body, err := c.registry.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodyLen {
+		return nil, fmt.Errorf("dmsg:Encode %s body is too large, len: %d", reflect.TypeOf(data), len(body))
+	}
+
+	buff := make([]byte, headSize, headSize+len(body))
+	binary.BigEndian.PutUint16(buff, uint16(len(body)))
+	binary.BigEndian.PutUint16(buff[lenSize:], cmd)
+	binary.BigEndian.PutUint16(buff[lenSize+cmdSize:], id)
+	return append(buff, body...), nil
+}
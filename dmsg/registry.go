@@ -0,0 +1,135 @@
+// Package dmsg maps the message ids to the message types, frames the messages with their ids,
+// and dispatches the received messages to the handlers by id.
+// Registry, Codec and Router plug into dnet.Options.Codec and dnet.Options.MsgCallback.
+package dmsg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrNilMarshaler  = errors.New("dmsg: marshaler is nil")
+	ErrNotPointer    = errors.New("dmsg: message must be a non-nil pointer")
+	ErrUnregistered  = errors.New("dmsg: message is not registered")
+	ErrUnknownID     = errors.New("dmsg: message id is unknown")
+	ErrDuplicateID   = errors.New("dmsg: message id is already registered")
+	ErrDuplicateType = errors.New("dmsg: message type is already registered")
+	ErrHandlerType   = errors.New("dmsg: handler must be a func(dnet.Session, *T)")
+)
+
+// Marshaler serializes the message bodies
+type Marshaler interface {
+	// Marshal returns the body of o
+	Marshal(o interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into o, which is a pointer to the registered type
+	Unmarshal(data []byte, o interface{}) error
+}
+
+// JSON is the Marshaler with encoding/json
+type JSON struct{}
+
+func (_ JSON) Marshal(o interface{}) ([]byte, error) {
+	return json.Marshal(o)
+}
+
+func (_ JSON) Unmarshal(data []byte, o interface{}) error {
+	return json.Unmarshal(data, o)
+}
+
+// Registry maps the message ids to the message types, it is safe for concurrent use.
+// Each side of a connection registers the same ids with its own Registry.
+type Registry struct {
+	marshaler Marshaler
+	lock      sync.RWMutex
+	id2Type   map[uint16]reflect.Type
+	type2ID   map[reflect.Type]uint16
+}
+
+// NewRegistry returns an empty registry serializing the messages with marshaler
+func NewRegistry(marshaler Marshaler) *Registry {
+	if marshaler == nil {
+		panic(ErrNilMarshaler)
+	}
+	return &Registry{
+		marshaler: marshaler,
+		id2Type:   map[uint16]reflect.Type{},
+		type2ID:   map[reflect.Type]uint16{},
+	}
+}
+
+// Register maps id to the type of msg, msg is a pointer such as (*Login)(nil) or &Login{}.
+// It returns an error if id or the type is already registered.
+func (r *Registry) Register(id uint16, msg interface{}) error {
+	tt := reflect.TypeOf(msg)
+	if tt == nil || tt.Kind() != reflect.Ptr {
+		return ErrNotPointer
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if old, ok := r.id2Type[id]; ok {
+		return fmt.Errorf("%w: %d to %s", ErrDuplicateID, id, old)
+	}
+	if old, ok := r.type2ID[tt]; ok {
+		return fmt.Errorf("%w: %s to %d", ErrDuplicateType, tt, old)
+	}
+	r.id2Type[id] = tt
+	r.type2ID[tt] = id
+	return nil
+}
+
+// MustRegister is like Register, but panics if it fails
+func (r *Registry) MustRegister(id uint16, msg interface{}) {
+	if err := r.Register(id, msg); err != nil {
+		panic(err)
+	}
+}
+
+// ID returns the id the type of msg is registered with
+func (r *Registry) ID(msg interface{}) (uint16, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	id, ok := r.type2ID[reflect.TypeOf(msg)]
+	return id, ok
+}
+
+// New returns a new message of the type registered with id
+func (r *Registry) New(id uint16) (interface{}, error) {
+	r.lock.RLock()
+	tt, ok := r.id2Type[id]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownID, id)
+	}
+	return reflect.New(tt.Elem()).Interface(), nil
+}
+
+// Marshal returns the id and the body of msg
+func (r *Registry) Marshal(msg interface{}) (uint16, []byte, error) {
+	id, ok := r.ID(msg)
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: %s", ErrUnregistered, reflect.TypeOf(msg))
+	}
+	data, err := r.marshaler.Marshal(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, data, nil
+}
+
+// Unmarshal decodes data into a new message of the type registered with id
+func (r *Registry) Unmarshal(id uint16, data []byte) (interface{}, error) {
+	msg, err := r.New(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.marshaler.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,100 @@
+package dmsg
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/yddeng/dnet"
+)
+
+// Handler handles a message dispatched by Router
+type Handler func(session dnet.Session, msg *Message)
+
+var sessionType = reflect.TypeOf((*dnet.Session)(nil)).Elem()
+
+// Router dispatches the messages decoded by Codec to the handlers by message id.
+// Dispatch is the dnet.Options.MsgCallback.
+type Router struct {
+	registry *Registry
+	lock     sync.RWMutex
+	handlers map[uint16]Handler
+	unknown  Handler
+}
+
+// NewRouter returns a router of the messages registered in registry
+func NewRouter(registry *Registry) *Router {
+	return &Router{registry: registry, handlers: map[uint16]Handler{}}
+}
+
+// Handle sets the handler of the registered type of msg, such as (*Login)(nil).
+// handler gets msg.Data of the type.
+func (r *Router) Handle(msg interface{}, handler Handler) error {
+	id, ok := r.registry.ID(msg)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnregistered, reflect.TypeOf(msg))
+	}
+	return r.HandleID(id, handler)
+}
+
+// HandleFunc sets fn as the handler of the registered type T, fn is a func(dnet.Session, *T) such as
+// func(session dnet.Session, msg *Login). fn gets msg.Data, use Handle to get the Cmd.
+func (r *Router) HandleFunc(fn interface{}) error {
+	ft := reflect.TypeOf(fn)
+	if ft == nil || ft.Kind() != reflect.Func || ft.NumIn() != 2 || ft.NumOut() != 0 ||
+		ft.In(0) != sessionType || ft.In(1).Kind() != reflect.Ptr {
+		return fmt.Errorf("%w: %v", ErrHandlerType, ft)
+	}
+	fv := reflect.ValueOf(fn)
+	if fv.IsNil() {
+		return fmt.Errorf("%w: %s is nil", ErrHandlerType, ft)
+	}
+	return r.Handle(reflect.Zero(ft.In(1)).Interface(), func(session dnet.Session, msg *Message) {
+		// session 可能为 nil，按接口类型传递
+		fv.Call([]reflect.Value{reflect.ValueOf(&session).Elem(), reflect.ValueOf(msg.Data)})
+	})
+}
+
+// HandleID sets the handler of id, it returns an error if id is not registered or already has a handler.
+func (r *Router) HandleID(id uint16, handler Handler) error {
+	if handler == nil {
+		return fmt.Errorf("dmsg: handler of %d is nil", id)
+	}
+	if _, err := r.registry.New(id); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.handlers[id]; ok {
+		return fmt.Errorf("dmsg: handler of %d is already set", id)
+	}
+	r.handlers[id] = handler
+	return nil
+}
+
+// HandleUnknown sets the handler of the messages without handler, or with the id unknown by the Registry.
+// They are dropped if it is not set.
+func (r *Router) HandleUnknown(handler Handler) {
+	r.lock.Lock()
+	r.unknown = handler
+	r.lock.Unlock()
+}
+
+// Dispatch calls the handler of message, message not a *Message is passed to the unknown handler
+// with the Data of it.
+func (r *Router) Dispatch(session dnet.Session, message interface{}) {
+	msg, isMsg := message.(*Message)
+	if !isMsg {
+		msg = &Message{Data: message}
+	}
+
+	r.lock.RLock()
+	handler, ok := r.handlers[msg.ID]
+	if !isMsg || !ok || msg.Data == nil {
+		handler = r.unknown
+	}
+	r.lock.RUnlock()
+	if handler != nil {
+		handler(session, msg)
+	}
+}
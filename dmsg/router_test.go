@@ -0,0 +1,134 @@
+package dmsg
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yddeng/dnet"
+)
+
+type login struct{ Name string }
+type ack struct{ Name string }
+type chat struct{ Text string }
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(JSON{})
+	if err := r.Register(1, login{}); err != ErrNotPointer {
+		t.Fatalf("register a non-pointer %v", err)
+	}
+	r.MustRegister(1, (*login)(nil))
+	if err := r.Register(1, &ack{}); !errors.Is(err, ErrDuplicateID) {
+		t.Fatalf("register the id again %v", err)
+	}
+	if err := r.Register(2, &login{}); !errors.Is(err, ErrDuplicateType) {
+		t.Fatalf("register the type again %v", err)
+	}
+	if _, err := NewCodec(r).Encode(&chat{}); !errors.Is(err, ErrUnregistered) {
+		t.Fatalf("encode an unregistered message %v", err)
+	}
+	router := NewRouter(r)
+	if err := router.Handle(&chat{}, func(dnet.Session, *Message) {}); !errors.Is(err, ErrUnregistered) {
+		t.Fatalf("handle an unregistered message %v", err)
+	}
+	if err := router.HandleID(3, func(dnet.Session, *Message) {}); !errors.Is(err, ErrUnknownID) {
+		t.Fatalf("handle an unknown id %v", err)
+	}
+	if err := router.HandleFunc(func(dnet.Session, *chat) {}); !errors.Is(err, ErrUnregistered) {
+		t.Fatalf("handle func of an unregistered message %v", err)
+	}
+	for _, fn := range []interface{}{nil, (func(dnet.Session, *login))(nil), func(*login) {},
+		func(dnet.Session, login) {}, func(dnet.Session, *login) error { return nil }} {
+		if err := router.HandleFunc(fn); !errors.Is(err, ErrHandlerType) {
+			t.Fatalf("handle func %T %v", fn, err)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	serverRegistry := NewRegistry(JSON{})
+	serverRegistry.MustRegister(1, (*login)(nil))
+	serverRegistry.MustRegister(2, (*ack)(nil))
+	router := NewRouter(serverRegistry)
+	if err := router.Handle((*login)(nil), func(session dnet.Session, msg *Message) {
+		_ = session.Send(&Message{Cmd: msg.Cmd, Data: &ack{Name: msg.Data.(*login).Name}})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// 服务器回显 ack
+	if err := router.HandleFunc(func(session dnet.Session, msg *ack) {
+		_ = session.Send(msg)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	unknown := make(chan *Message, 1)
+	router.HandleUnknown(func(session dnet.Session, msg *Message) {
+		unknown <- msg
+	})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		dnet.NewTCPSession(conn, dnet.WithCodec(NewCodec(serverRegistry)), dnet.WithMessageCallback(router.Dispatch))
+	}()
+
+	// 客户端注册了服务器未知的消息
+	clientRegistry := NewRegistry(JSON{})
+	clientRegistry.MustRegister(1, (*login)(nil))
+	clientRegistry.MustRegister(2, (*ack)(nil))
+	clientRegistry.MustRegister(3, (*chat)(nil))
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := make(chan *Message, 1)
+	session := dnet.NewTCPSession(conn, dnet.WithCodec(NewCodec(clientRegistry)),
+		dnet.WithMessageCallback(func(session dnet.Session, message interface{}) {
+			recv <- message.(*Message)
+		}))
+	defer session.Close(nil)
+
+	if err := session.Send(&Message{Cmd: 7, Data: &login{Name: "dnet"}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recv:
+		if a, ok := msg.Data.(*ack); !ok || a.Name != "dnet" || msg.Cmd != 7 || msg.ID != 2 {
+			t.Fatalf("recv %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("recv timeout")
+	}
+
+	if err := session.Send(&ack{Name: "typed"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recv:
+		if a, ok := msg.Data.(*ack); !ok || a.Name != "typed" || msg.Cmd != 0 {
+			t.Fatalf("recv %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("recv timeout")
+	}
+
+	if err := session.Send(&chat{Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-unknown:
+		if msg.ID != 3 || msg.Data != nil || string(msg.Body) != `{"Text":"hi"}` {
+			t.Fatalf("unknown %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("unknown timeout")
+	}
+}
@@ -0,0 +1,77 @@
+package dmux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	protoVersion byte = 0
+	headerSize        = 12
+
+	// the window of a new stream, both sides assume it before the window update of the peer
+	initialStreamWindow uint32 = 256 * 1024
+)
+
+// the types of the frames
+const (
+	typeData         byte = iota // length is the size of the payload
+	typeWindowUpdate             // length is the increment of the send window
+	typePing                     // length is the ping id
+	typeGoAway                   // length is the code
+)
+
+// the flags of the frames
+const (
+	flagSYN uint16 = 1 << iota // opens a stream, or a ping
+	flagACK                    // accepts a stream, or a pong
+	flagFIN                    // half-closes a stream
+	flagRST                    // resets a stream
+)
+
+// the codes of the go away frames
+const (
+	goAwayNormal uint32 = iota
+	goAwayProtoErr
+)
+
+var (
+	ErrSessionShutdown    = errors.New("dmux: session is shutdown")
+	ErrStreamClosed       = errors.New("dmux: stream is closed")
+	ErrStreamReset        = errors.New("dmux: stream is reset by the peer")
+	ErrStreamsExhausted   = errors.New("dmux: stream ids are exhausted")
+	ErrRemoteGoAway       = errors.New("dmux: the peer doesn't accept new streams")
+	ErrRecvWindowExceeded = errors.New("dmux: the peer exceeds the receive window")
+	ErrKeepAliveTimeout   = errors.New("dmux: keepalive timeout")
+	ErrProtocol           = errors.New("dmux: protocol error")
+)
+
+// timeoutError is returned when the deadline of read or write is exceeded
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "dmux: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// header is the header of a frame: version(1) type(1) flags(2) stream id(4) length(4)
+type header []byte
+
+func newHeader(typ byte, flags uint16, id, length uint32) header {
+	h := make(header, headerSize)
+	h[0] = protoVersion
+	h[1] = typ
+	binary.BigEndian.PutUint16(h[2:], flags)
+	binary.BigEndian.PutUint32(h[4:], id)
+	binary.BigEndian.PutUint32(h[8:], length)
+	return h
+}
+
+func (h header) Version() byte    { return h[0] }
+func (h header) Type() byte       { return h[1] }
+func (h header) Flags() uint16    { return binary.BigEndian.Uint16(h[2:]) }
+func (h header) StreamID() uint32 { return binary.BigEndian.Uint32(h[4:]) }
+func (h header) Length() uint32   { return binary.BigEndian.Uint32(h[8:]) }
+func (h header) String() string {
+	return fmt.Sprintf("version:%d type:%d flags:%d stream:%d length:%d", h.Version(), h.Type(), h.Flags(), h.StreamID(), h.Length())
+}
@@ -0,0 +1,99 @@
+package dmux
+
+import "time"
+
+const (
+	defAcceptBacklog = 256
+	defWriteTimeout  = 10 * time.Second
+
+	defStreamCloseTimeout = 30 * time.Second
+)
+
+type Option func(*Options)
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.AcceptBacklog <= 0 {
+		opts.AcceptBacklog = defAcceptBacklog
+	}
+	if opts.StreamWindowSize < initialStreamWindow {
+		opts.StreamWindowSize = initialStreamWindow
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defWriteTimeout
+	}
+	if opts.StreamCloseTimeout <= 0 {
+		opts.StreamCloseTimeout = defStreamCloseTimeout
+	}
+	// 没有超时的 ping 不会结束，保活无法发现断开的连接
+	if opts.KeepAliveInterval > 0 && opts.KeepAliveTimeout <= 0 {
+		opts.KeepAliveTimeout = opts.KeepAliveInterval
+	}
+	return opts
+}
+
+type Options struct {
+	// the max number of streams opened by the peer and not accepted, the more are reset. default dmux.defAcceptBacklog
+	AcceptBacklog int
+
+	// the max number of bytes the peer can send on a stream before it is read. default and min dmux.initialStreamWindow
+	StreamWindowSize uint32
+
+	// send a ping every KeepAliveInterval, and close the session with ErrKeepAliveTimeout
+	// if the pong is not received in KeepAliveTimeout. 0 means no keepalive,
+	// KeepAliveTimeout defaults to KeepAliveInterval
+	KeepAliveInterval time.Duration
+	KeepAliveTimeout  time.Duration
+
+	// the deadline of writing a frame to the connection, the session is closed if it is exceeded. default dmux.defWriteTimeout
+	WriteTimeout time.Duration
+
+	// the time a stream closed by Close waits for the peer to close it, then it is reset. default dmux.defStreamCloseTimeout
+	StreamCloseTimeout time.Duration
+}
+
+// WithOptions accepts the whole options config.
+func WithOptions(option *Options) Option {
+	return func(opt *Options) {
+		*opt = *option
+	}
+}
+
+// WithAcceptBacklog sets the max number of streams not accepted.
+func WithAcceptBacklog(backlog int) Option {
+	return func(opt *Options) {
+		opt.AcceptBacklog = backlog
+	}
+}
+
+// WithStreamWindowSize sets the receive window of the streams.
+func WithStreamWindowSize(size uint32) Option {
+	return func(opt *Options) {
+		opt.StreamWindowSize = size
+	}
+}
+
+// WithKeepAlive sets the interval and timeout of the keepalive pings.
+func WithKeepAlive(interval, timeout time.Duration) Option {
+	return func(opt *Options) {
+		opt.KeepAliveInterval = interval
+		opt.KeepAliveTimeout = timeout
+	}
+}
+
+// WithStreamCloseTimeout sets the time a closed stream waits for the peer to close it.
+func WithStreamCloseTimeout(timeout time.Duration) Option {
+	return func(opt *Options) {
+		opt.StreamCloseTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets the deadline of writing a frame.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(opt *Options) {
+		opt.WriteTimeout = timeout
+	}
+}
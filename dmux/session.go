@@ -0,0 +1,468 @@
+// Package dmux multiplexes the streams over a single connection, in the style of yamux.
+// Each stream is a net.Conn with its own flow-control window, so NewTCPSession and drpc
+// can run on a stream without knowing about the multiplexer.
+package dmux
+
+import (
+	"io"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 读协程发送的控制帧（pong、RST）的队列长度
+const ctrlQueueSize = 64
+
+// sendReady is a frame waiting for the writer
+type sendReady struct {
+	hdr  header
+	body []byte
+	err  chan error
+}
+
+// Session multiplexes the streams over a connection. The client opens the odd stream ids,
+// and the server opens the even ones, both sides can open and accept the streams.
+// It implements net.Listener, Accept returns the streams opened by the peer.
+type Session struct {
+	conn net.Conn
+	opts *Options
+
+	lock     sync.Mutex
+	streams  map[uint32]*Stream
+	nextID   uint32
+	acceptCh chan *Stream
+
+	pingLock sync.Mutex
+	pingID   uint32
+	pings    map[uint32]chan struct{}
+
+	sendCh     chan *sendReady
+	ctrlCh     chan header // 读协程发送的控制帧
+	writerDone chan struct{}
+
+	localGoAway  int32
+	remoteGoAway int32
+
+	shutdown     int32
+	shutdownErr  error
+	shutdownLock sync.Mutex
+	chShutdown   chan struct{}
+}
+
+// Client returns the client side session over conn
+func Client(conn net.Conn, options ...Option) *Session {
+	return newSession(conn, true, loadOptions(options...))
+}
+
+// Server returns the server side session over conn
+func Server(conn net.Conn, options ...Option) *Session {
+	return newSession(conn, false, loadOptions(options...))
+}
+
+func newSession(conn net.Conn, client bool, opts *Options) *Session {
+	session := &Session{
+		conn:       conn,
+		opts:       opts,
+		streams:    map[uint32]*Stream{},
+		acceptCh:   make(chan *Stream, opts.AcceptBacklog),
+		pings:      map[uint32]chan struct{}{},
+		sendCh:     make(chan *sendReady, 64),
+		ctrlCh:     make(chan header, ctrlQueueSize),
+		writerDone: make(chan struct{}),
+		chShutdown: make(chan struct{}),
+	}
+	if client {
+		session.nextID = 1
+	} else {
+		session.nextID = 2
+	}
+
+	go session.readThread()
+	go session.writeThread()
+	if opts.KeepAliveInterval > 0 {
+		go session.keepalive()
+	}
+	return session
+}
+
+// Open opens a new stream
+func (this *Session) Open() (net.Conn, error) {
+	stream, err := this.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// OpenStream opens a new stream, the peer can write to it before it is accepted
+func (this *Session) OpenStream() (*Stream, error) {
+	if this.IsClosed() {
+		return nil, ErrSessionShutdown
+	}
+	if atomic.LoadInt32(&this.remoteGoAway) == 1 {
+		return nil, ErrRemoteGoAway
+	}
+
+	this.lock.Lock()
+	id := this.nextID
+	if id >= math.MaxUint32-1 {
+		this.lock.Unlock()
+		return nil, ErrStreamsExhausted
+	}
+	this.nextID += 2
+	stream := newStream(this, id, flagSYN)
+	this.streams[id] = stream
+	this.lock.Unlock()
+
+	if err := stream.sendWindowUpdate(); err != nil {
+		this.closeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept waits for and returns the next stream opened by the peer
+func (this *Session) Accept() (net.Conn, error) {
+	stream, err := this.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer
+func (this *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-this.acceptCh:
+		if err := stream.sendWindowUpdate(); err != nil {
+			return nil, err
+		}
+		return stream, nil
+	case <-this.chShutdown:
+		return nil, this.err()
+	}
+}
+
+// Addr returns the local address of the connection
+func (this *Session) Addr() net.Addr {
+	return this.conn.LocalAddr()
+}
+
+// LocalAddr returns the local address of the connection
+func (this *Session) LocalAddr() net.Addr {
+	return this.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the connection
+func (this *Session) RemoteAddr() net.Addr {
+	return this.conn.RemoteAddr()
+}
+
+// NumStreams returns the number of the streams not closed
+func (this *Session) NumStreams() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.streams)
+}
+
+// GoAway tells the peer not to open new streams, the existing streams are not affected
+func (this *Session) GoAway() error {
+	atomic.StoreInt32(&this.localGoAway, 1)
+	return this.send(newHeader(typeGoAway, 0, 0, goAwayNormal), nil, time.Time{})
+}
+
+// Ping sends a ping and returns the round-trip time
+func (this *Session) Ping() (time.Duration, error) {
+	ch := make(chan struct{})
+	this.pingLock.Lock()
+	this.pingID++
+	id := this.pingID
+	this.pings[id] = ch
+	this.pingLock.Unlock()
+	defer func() {
+		this.pingLock.Lock()
+		delete(this.pings, id)
+		this.pingLock.Unlock()
+	}()
+
+	start := time.Now()
+	if err := this.send(newHeader(typePing, flagSYN, 0, id), nil, time.Time{}); err != nil {
+		return 0, err
+	}
+	var timeout <-chan time.Time
+	if this.opts.KeepAliveTimeout > 0 {
+		timer := time.NewTimer(this.opts.KeepAliveTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-timeout:
+		return 0, ErrKeepAliveTimeout
+	case <-this.chShutdown:
+		return 0, this.err()
+	}
+}
+
+func (this *Session) keepalive() {
+	ticker := time.NewTicker(this.opts.KeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := this.Ping(); err == ErrKeepAliveTimeout {
+				this.close(err)
+				return
+			}
+		case <-this.chShutdown:
+			return
+		}
+	}
+}
+
+// IsClosed reports whether the session is closed
+func (this *Session) IsClosed() bool {
+	return atomic.LoadInt32(&this.shutdown) == 1
+}
+
+// CloseChan returns a channel closed after the session is closed
+func (this *Session) CloseChan() <-chan struct{} {
+	return this.chShutdown
+}
+
+// Err returns the reason why the session is closed, or nil if it is open
+func (this *Session) Err() error {
+	if !this.IsClosed() {
+		return nil
+	}
+	return this.err()
+}
+
+func (this *Session) err() error {
+	this.shutdownLock.Lock()
+	defer this.shutdownLock.Unlock()
+	return this.shutdownErr
+}
+
+// Close closes the connection and all the streams
+func (this *Session) Close() error {
+	this.close(ErrSessionShutdown)
+	return nil
+}
+
+func (this *Session) close(reason error) {
+	this.shutdownLock.Lock()
+	if this.shutdownErr != nil {
+		this.shutdownLock.Unlock()
+		return
+	}
+	this.shutdownErr = reason
+	this.shutdownLock.Unlock()
+
+	atomic.StoreInt32(&this.shutdown, 1)
+	close(this.chShutdown)
+	_ = this.conn.Close()
+
+	this.lock.Lock()
+	streams := this.streams
+	this.streams = map[uint32]*Stream{}
+	this.lock.Unlock()
+	for _, stream := range streams {
+		stream.notifyAll()
+	}
+}
+
+// send puts the frame in the send queue, and waits until it is written.
+// body is not used by the session after send returns.
+func (this *Session) send(hdr header, body []byte, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	ready := &sendReady{hdr: hdr, body: body, err: make(chan error, 1)}
+	select {
+	case this.sendCh <- ready:
+	case <-this.chShutdown:
+		return this.err()
+	case <-timeout:
+		return timeoutError{}
+	}
+
+	// 已进入队列，等待写出后才能返回
+	select {
+	case err := <-ready.err:
+		return err
+	case <-this.writerDone:
+		return this.err()
+	}
+}
+
+// sendControl puts the control frame sent by the reader in the bounded control queue.
+// A pong is dropped if the queue is full, the other frames wait for the space.
+func (this *Session) sendControl(hdr header) {
+	select {
+	case this.ctrlCh <- hdr:
+		return
+	default:
+	}
+	if hdr.Type() == typePing {
+		// 对端发送的 ping 过多
+		return
+	}
+	select {
+	case this.ctrlCh <- hdr:
+	case <-this.chShutdown:
+	}
+}
+
+func (this *Session) writeThread() {
+	defer close(this.writerDone)
+	for {
+		var err error
+		select {
+		case hdr := <-this.ctrlCh:
+			err = this.write(net.Buffers{hdr})
+		case ready := <-this.sendCh:
+			bufs := net.Buffers{ready.hdr}
+			if len(ready.body) > 0 {
+				bufs = append(bufs, ready.body)
+			}
+			err = this.write(bufs)
+			ready.err <- err
+		case <-this.chShutdown:
+			return
+		}
+		if err != nil {
+			this.close(err)
+			return
+		}
+	}
+}
+
+func (this *Session) write(bufs net.Buffers) error {
+	_ = this.conn.SetWriteDeadline(time.Now().Add(this.opts.WriteTimeout))
+	_, err := bufs.WriteTo(this.conn)
+	return err
+}
+
+func (this *Session) readThread() {
+	hdr := make(header, headerSize)
+	for {
+		if _, err := io.ReadFull(this.conn, hdr); err != nil {
+			this.close(err)
+			return
+		}
+		if hdr.Version() != protoVersion {
+			this.close(ErrProtocol)
+			return
+		}
+
+		var err error
+		switch hdr.Type() {
+		case typeData, typeWindowUpdate:
+			err = this.handleStream(hdr)
+		case typePing:
+			this.handlePing(hdr)
+		case typeGoAway:
+			err = this.handleGoAway(hdr)
+		default:
+			err = ErrProtocol
+		}
+		if err != nil {
+			if err == ErrProtocol || err == ErrRecvWindowExceeded {
+				_ = this.send(newHeader(typeGoAway, 0, 0, goAwayProtoErr), nil, time.Now().Add(this.opts.WriteTimeout))
+			}
+			this.close(err)
+			return
+		}
+	}
+}
+
+func (this *Session) handleStream(hdr header) error {
+	id, flags := hdr.StreamID(), hdr.Flags()
+	if flags&flagSYN != 0 {
+		if err := this.incomingStream(id); err != nil {
+			return err
+		}
+	}
+
+	this.lock.Lock()
+	stream := this.streams[id]
+	this.lock.Unlock()
+	if stream == nil {
+		// 已关闭的流，丢弃数据
+		if hdr.Type() == typeData && hdr.Length() > 0 {
+			if _, err := io.CopyN(io.Discard, this.conn, int64(hdr.Length())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if hdr.Type() == typeWindowUpdate {
+		stream.incrSendWindow(hdr.Length())
+	} else if err := stream.readData(hdr.Length()); err != nil {
+		return err
+	}
+	stream.processFlags(flags)
+	return nil
+}
+
+// incomingStream registers the stream opened by the peer, it is reset if the backlog is full
+func (this *Session) incomingStream(id uint32) error {
+	this.lock.Lock()
+	if _, ok := this.streams[id]; ok || id == 0 || id%2 == this.nextID%2 {
+		this.lock.Unlock()
+		return ErrProtocol
+	}
+	if atomic.LoadInt32(&this.localGoAway) == 1 {
+		this.lock.Unlock()
+		this.sendControl(newHeader(typeWindowUpdate, flagRST, id, 0))
+		return nil
+	}
+	stream := newStream(this, id, flagACK)
+	select {
+	case this.acceptCh <- stream:
+		this.streams[id] = stream
+		this.lock.Unlock()
+	default:
+		this.lock.Unlock()
+		this.sendControl(newHeader(typeWindowUpdate, flagRST, id, 0))
+	}
+	return nil
+}
+
+func (this *Session) handlePing(hdr header) {
+	id := hdr.Length()
+	if hdr.Flags()&flagSYN != 0 {
+		this.sendControl(newHeader(typePing, flagACK, 0, id))
+		return
+	}
+	this.pingLock.Lock()
+	if ch, ok := this.pings[id]; ok {
+		close(ch)
+		delete(this.pings, id)
+	}
+	this.pingLock.Unlock()
+}
+
+func (this *Session) handleGoAway(hdr header) error {
+	if hdr.Length() != goAwayNormal {
+		return ErrProtocol
+	}
+	atomic.StoreInt32(&this.remoteGoAway, 1)
+	return nil
+}
+
+// closeStream removes the stream after both sides close it, or it is reset
+func (this *Session) closeStream(id uint32) {
+	this.lock.Lock()
+	delete(this.streams, id)
+	this.lock.Unlock()
+}
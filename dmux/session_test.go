@@ -0,0 +1,204 @@
+package dmux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/yddeng/dnet"
+)
+
+func TestSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	servers := make(chan *Session, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		servers <- Server(conn)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client(conn)
+	defer client.Close()
+	server := <-servers
+
+	// 流上运行 dnet 会话
+	go func() {
+		stream, err := server.Accept()
+		if err != nil {
+			return
+		}
+		dnet.NewTCPSession(stream, dnet.WithMessageCallback(func(session dnet.Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+	}()
+	stream, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := make(chan byte, 16)
+	session := dnet.NewTCPSession(stream, dnet.WithMessageCallback(func(session dnet.Session, message interface{}) {
+		recv <- message.([]byte)[0]
+	}))
+	echo := func(b byte) {
+		if err := session.Send([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-recv:
+			if r != b {
+				t.Fatalf("echo %d, want %d", r, b)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("echo %d timeout", b)
+		}
+	}
+	echo(1)
+
+	// 对端不读时写满窗口，不影响其他流
+	bulk, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1024*1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	_ = bulk.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := bulk.Write(data)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("write beyond the window %v", err)
+	}
+	if n != int(initialStreamWindow) {
+		t.Fatalf("write %d beyond the window %d", n, initialStreamWindow)
+	}
+	echo(2)
+
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = bulk.SetWriteDeadline(time.Time{})
+	go func() {
+		if _, err := bulk.Write(data[n:]); err != nil {
+			return
+		}
+		_ = bulk.CloseWrite()
+	}()
+	got, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, not the written", len(got))
+	}
+
+	// 半关闭后仍可读
+	if _, err := peer.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	_ = peer.Close()
+	if got, err := io.ReadAll(bulk); err != nil || string(got) != "bye" {
+		t.Fatalf("read %q %v after half-closed", got, err)
+	}
+	echo(3)
+
+	// 关闭会话
+	session.Close(nil)
+	client.Close()
+	select {
+	case <-server.CloseChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server close timeout")
+	}
+	if _, err := server.Accept(); err == nil {
+		t.Fatal("accept on the closed session")
+	}
+	if server.NumStreams() != 0 {
+		t.Fatalf("%d streams after closed", server.NumStreams())
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	// 对端不回复 pong
+	go io.Copy(io.Discard, c2)
+
+	session := Client(c1, WithKeepAlive(50*time.Millisecond, 0))
+	defer session.Close()
+	select {
+	case <-session.CloseChan():
+		if err := session.Err(); err != ErrKeepAliveTimeout {
+			t.Fatalf("closed by %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepalive timeout is not detected")
+	}
+}
+
+func TestPingFlood(t *testing.T) {
+	c1, c2 := net.Pipe()
+	session := Server(c1)
+	defer session.Close()
+	defer c2.Close()
+
+	// 对端发送大量 ping 且不读取 pong
+	base := runtime.NumGoroutine()
+	for i := 0; i < 10000; i++ {
+		if _, err := c2.Write(newHeader(typePing, flagSYN, 0, uint32(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := runtime.NumGoroutine(); n > base+10 {
+		t.Fatalf("%d goroutines after the ping flood, %d before", n, base)
+	}
+	if session.IsClosed() {
+		t.Fatal("session closed by the ping flood")
+	}
+}
+
+func TestStreamCloseTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := Client(c1, WithStreamCloseTimeout(50*time.Millisecond))
+	defer client.Close()
+	server := Server(c2)
+	defer server.Close()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 对端不关闭，超时后流被重置并移除
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("peer read %v, want EOF", err)
+	}
+	for i := 0; client.NumStreams() != 0 || server.NumStreams() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("%d %d streams after closed", client.NumStreams(), server.NumStreams())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := peer.Write([]byte{1}); err != ErrStreamReset {
+		t.Fatalf("peer write %v, want %v", err, ErrStreamReset)
+	}
+}
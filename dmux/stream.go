@@ -0,0 +1,358 @@
+package dmux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// the max payload of a data frame, the frames of the streams are interleaved on the connection
+const maxDataFrame = 32 * 1024
+
+// Stream is a logical connection in the session, it implements net.Conn.
+// A stream can be written up to the window the peer grants, and the peer grants more
+// after the data is read, so a slow reader doesn't block the other streams.
+type Stream struct {
+	id      uint32
+	session *Session
+
+	writeLock sync.Mutex // 保证一次 Write 的数据连续
+
+	lock          sync.Mutex
+	recvBuf       bytes.Buffer
+	recvWindow    uint32
+	sendWindow    uint32
+	flags         uint16 // 待发送的 SYN 或 ACK
+	readClosed    bool
+	localClosed   bool
+	remoteClosed  bool
+	reset         bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readable      chan struct{}
+	writable      chan struct{}
+}
+
+func newStream(session *Session, id uint32, flags uint16) *Stream {
+	return &Stream{
+		id:         id,
+		session:    session,
+		recvWindow: initialStreamWindow,
+		sendWindow: initialStreamWindow,
+		flags:      flags,
+		readable:   make(chan struct{}, 1),
+		writable:   make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (this *Stream) notifyAll() {
+	notify(this.readable)
+	notify(this.writable)
+}
+
+// wait releases the lock and waits for ch, until the deadline or the session is closed
+func (this *Stream) wait(ch chan struct{}, deadline time.Time) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	this.lock.Unlock()
+	select {
+	case <-ch:
+	case <-timeout:
+	case <-this.session.chShutdown:
+	}
+	this.lock.Lock()
+}
+
+// ID returns the stream id
+func (this *Stream) ID() uint32 {
+	return this.id
+}
+
+// Session returns the session the stream belongs to
+func (this *Stream) Session() *Session {
+	return this.session
+}
+
+// Read reads the data received, it returns io.EOF after the peer closes the stream.
+func (this *Stream) Read(b []byte) (int, error) {
+	this.lock.Lock()
+	for this.recvBuf.Len() == 0 {
+		if this.readClosed {
+			this.lock.Unlock()
+			return 0, ErrStreamClosed
+		}
+		if this.reset {
+			this.lock.Unlock()
+			return 0, ErrStreamReset
+		}
+		if this.remoteClosed {
+			this.lock.Unlock()
+			return 0, io.EOF
+		}
+		if this.session.IsClosed() {
+			this.lock.Unlock()
+			return 0, this.session.err()
+		}
+		if !this.readDeadline.IsZero() && !time.Now().Before(this.readDeadline) {
+			this.lock.Unlock()
+			return 0, timeoutError{}
+		}
+		this.wait(this.readable, this.readDeadline)
+	}
+	n, _ := this.recvBuf.Read(b)
+	this.lock.Unlock()
+
+	// 会话关闭时下次读取返回错误
+	_ = this.sendWindowUpdate()
+	return n, nil
+}
+
+// sendWindowUpdate grants the peer the window freed by reading, when it is at least half of the window.
+// The pending SYN or ACK is always sent.
+func (this *Stream) sendWindowUpdate() error {
+	this.lock.Lock()
+	max := this.session.opts.StreamWindowSize
+	delta := max - uint32(this.recvBuf.Len()) - this.recvWindow
+	flags := this.flags
+	if this.readClosed || this.remoteClosed || this.reset {
+		delta = 0
+	}
+	if flags == 0 && (delta == 0 || delta < max/2) {
+		this.lock.Unlock()
+		return nil
+	}
+	this.recvWindow += delta
+	this.flags = 0
+	this.lock.Unlock()
+	return this.session.send(newHeader(typeWindowUpdate, flags, this.id, delta), nil, time.Time{})
+}
+
+// Write writes the data within the send window, it blocks while the window is used up.
+func (this *Stream) Write(b []byte) (int, error) {
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+
+	n := 0
+	this.lock.Lock()
+	for n < len(b) {
+		if this.localClosed {
+			this.lock.Unlock()
+			return n, ErrStreamClosed
+		}
+		if this.reset {
+			this.lock.Unlock()
+			return n, ErrStreamReset
+		}
+		if this.session.IsClosed() {
+			this.lock.Unlock()
+			return n, this.session.err()
+		}
+		if !this.writeDeadline.IsZero() && !time.Now().Before(this.writeDeadline) {
+			this.lock.Unlock()
+			return n, timeoutError{}
+		}
+		if this.sendWindow == 0 {
+			this.wait(this.writable, this.writeDeadline)
+			continue
+		}
+
+		chunk := uint32(len(b) - n)
+		if chunk > this.sendWindow {
+			chunk = this.sendWindow
+		}
+		if chunk > maxDataFrame {
+			chunk = maxDataFrame
+		}
+		this.sendWindow -= chunk
+		flags := this.flags
+		this.flags = 0
+		deadline := this.writeDeadline
+		this.lock.Unlock()
+
+		err := this.session.send(newHeader(typeData, flags, this.id, chunk), b[n:n+int(chunk)], deadline)
+		if err != nil {
+			if _, ok := err.(timeoutError); ok {
+				// 未进入发送队列
+				this.lock.Lock()
+				this.sendWindow += chunk
+				this.flags |= flags
+				this.lock.Unlock()
+			}
+			return n, err
+		}
+		n += int(chunk)
+		this.lock.Lock()
+	}
+	this.lock.Unlock()
+	return n, nil
+}
+
+// incrSendWindow is called on the window update of the peer
+func (this *Stream) incrSendWindow(delta uint32) {
+	this.lock.Lock()
+	this.sendWindow += delta
+	this.lock.Unlock()
+	notify(this.writable)
+}
+
+// readData reads the payload of a data frame from the connection
+func (this *Stream) readData(length uint32) error {
+	if length == 0 {
+		return nil
+	}
+	this.lock.Lock()
+	if length > this.recvWindow {
+		this.lock.Unlock()
+		return ErrRecvWindowExceeded
+	}
+	this.recvWindow -= length
+	this.lock.Unlock()
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(this.session.conn, data); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	if !this.readClosed {
+		this.recvBuf.Write(data)
+	}
+	this.lock.Unlock()
+	notify(this.readable)
+	return nil
+}
+
+// processFlags handles the flags of a frame from the peer
+func (this *Stream) processFlags(flags uint16) {
+	if flags&(flagFIN|flagRST) == 0 {
+		return
+	}
+	this.lock.Lock()
+	if flags&flagRST != 0 {
+		this.reset = true
+	} else {
+		this.remoteClosed = true
+	}
+	done := this.reset || this.localClosed
+	this.lock.Unlock()
+
+	this.notifyAll()
+	if done {
+		this.session.closeStream(this.id)
+	}
+}
+
+// CloseWrite half-closes the stream, the peer reads io.EOF after the data written, and the stream can still be read.
+func (this *Stream) CloseWrite() error {
+	return this.close(false)
+}
+
+// Close closes the stream, the data received and not read is dropped.
+// The stream is reset if the peer doesn't close it in StreamCloseTimeout.
+func (this *Stream) Close() error {
+	return this.close(true)
+}
+
+func (this *Stream) close(read bool) error {
+	this.lock.Lock()
+	linger := false
+	if read && !this.readClosed {
+		this.readClosed = true
+		this.recvBuf.Reset()
+		linger = !this.remoteClosed && !this.reset
+	}
+	if this.localClosed {
+		this.lock.Unlock()
+		this.notifyAll()
+		if linger {
+			time.AfterFunc(this.session.opts.StreamCloseTimeout, this.abort)
+		}
+		return nil
+	}
+	this.localClosed = true
+	flags := this.flags | flagFIN
+	this.flags = 0
+	reset := this.reset
+	done := this.remoteClosed || this.reset
+	this.lock.Unlock()
+
+	this.notifyAll()
+	if done {
+		this.session.closeStream(this.id)
+	}
+	if reset || this.session.IsClosed() {
+		return nil
+	}
+	err := this.session.send(newHeader(typeWindowUpdate, flags, this.id, 0), nil, time.Now().Add(this.session.opts.WriteTimeout))
+	if linger {
+		// 对端一直不关闭时重置，移除流
+		time.AfterFunc(this.session.opts.StreamCloseTimeout, this.abort)
+	}
+	return err
+}
+
+// abort resets the stream closed locally, if the peer doesn't close it
+func (this *Stream) abort() {
+	this.lock.Lock()
+	done := this.remoteClosed || this.reset
+	this.reset = true
+	this.lock.Unlock()
+	if done {
+		return
+	}
+
+	this.notifyAll()
+	this.session.closeStream(this.id)
+	if !this.session.IsClosed() {
+		_ = this.session.send(newHeader(typeWindowUpdate, flagRST, this.id, 0), nil, time.Now().Add(this.session.opts.WriteTimeout))
+	}
+}
+
+// LocalAddr returns the local address of the connection
+func (this *Stream) LocalAddr() net.Addr {
+	return this.session.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the connection
+func (this *Stream) RemoteAddr() net.Addr {
+	return this.session.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines associated with the stream.
+func (this *Stream) SetDeadline(t time.Time) error {
+	if err := this.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return this.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls and any currently-blocked Read call.
+func (this *Stream) SetReadDeadline(t time.Time) error {
+	this.lock.Lock()
+	this.readDeadline = t
+	this.lock.Unlock()
+	notify(this.readable)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls and any currently-blocked Write call.
+func (this *Stream) SetWriteDeadline(t time.Time) error {
+	this.lock.Lock()
+	this.writeDeadline = t
+	this.lock.Unlock()
+	notify(this.writable)
+	return nil
+}
@@ -0,0 +1,217 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package dnet
+
+import (
+	"errors"
+	"github.com/yddeng/dnet/poller"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const defEventLoopBufferSize = 64 * 1024
+
+var ErrEventLoopStopped = errors.New("dnet: event loop is stopped. ")
+
+// EventLoopGroup is a fixed set of event loops.
+// Each event loop drives a poller in one goroutine and serves all sessions attached to it.
+type EventLoopGroup struct {
+	loops   []*eventLoop
+	next    uint32
+	balance int32
+	stopped int32
+}
+
+// LoadBalance is the way an EventLoopGroup picks the event loop for a new session
+type LoadBalance int32
+
+const (
+	// RoundRobin picks the event loops in turn, it is the default
+	RoundRobin LoadBalance = iota
+	// LeastConnections picks the event loop with the fewest sessions
+	LeastConnections
+)
+
+// NewEventLoopGroup returns a started group with num event loops. num <= 0 means runtime.NumCPU()
+func NewEventLoopGroup(num int) (*EventLoopGroup, error) {
+	if num <= 0 {
+		num = runtime.NumCPU()
+	}
+
+	group := &EventLoopGroup{loops: make([]*eventLoop, 0, num)}
+	for i := 0; i < num; i++ {
+		p, err := poller.OpenPoller()
+		if err != nil {
+			group.Stop()
+			return nil, err
+		}
+		loop := newEventLoop(p)
+		group.loops = append(group.loops, loop)
+		go loop.run()
+	}
+	return group, nil
+}
+
+var (
+	defEventLoopGroup     *EventLoopGroup
+	defEventLoopGroupErr  error
+	defEventLoopGroupOnce sync.Once
+)
+
+// DefaultEventLoopGroup returns the group used by NewEventLoopTCPSession, it is started on first use.
+func DefaultEventLoopGroup() (*EventLoopGroup, error) {
+	defEventLoopGroupOnce.Do(func() {
+		defEventLoopGroup, defEventLoopGroupErr = NewEventLoopGroup(0)
+	})
+	return defEventLoopGroup, defEventLoopGroupErr
+}
+
+// Connections returns the number of sessions attached to the group
+func (this *EventLoopGroup) Connections() int {
+	n := 0
+	for _, loop := range this.loops {
+		n += int(atomic.LoadInt32(&loop.conns))
+	}
+	return n
+}
+
+// SetLoadBalance sets the way to pick the event loop for new sessions
+func (this *EventLoopGroup) SetLoadBalance(lb LoadBalance) {
+	atomic.StoreInt32(&this.balance, int32(lb))
+}
+
+// Stop stops all event loops, sessions still attached are closed with ErrEventLoopStopped.
+func (this *EventLoopGroup) Stop() {
+	if atomic.CompareAndSwapInt32(&this.stopped, 0, 1) {
+		for _, loop := range this.loops {
+			loop.stop()
+		}
+	}
+}
+
+// pick returns the event loop for a new session, and counts the session in it
+func (this *EventLoopGroup) pick() *eventLoop {
+	var loop *eventLoop
+	if LoadBalance(atomic.LoadInt32(&this.balance)) == LeastConnections {
+		loop = this.loops[0]
+		for _, l := range this.loops[1:] {
+			if atomic.LoadInt32(&l.conns) < atomic.LoadInt32(&loop.conns) {
+				loop = l
+			}
+		}
+	} else {
+		idx := atomic.AddUint32(&this.next, 1)
+		loop = this.loops[int(idx)%len(this.loops)]
+	}
+	atomic.AddInt32(&loop.conns, 1)
+	return loop
+}
+
+type eventLoop struct {
+	poller   *poller.Poller
+	sessions map[int]*EventLoopTCPSession // 只在事件循环中访问
+	buffer   []byte                       // 读缓存，所有会话共用
+	conns    int32                        // 分配到此循环且未关闭的会话数
+	busy     int32                        // 事件循环正在处理事件或任务
+
+	taskLock sync.Mutex
+	tasks    []func()
+	stopped  bool
+}
+
+func newEventLoop(p *poller.Poller) *eventLoop {
+	return &eventLoop{
+		poller:   p,
+		sessions: map[int]*EventLoopTCPSession{},
+		buffer:   make([]byte, defEventLoopBufferSize),
+	}
+}
+
+// execute runs task in the event loop goroutine.
+func (l *eventLoop) execute(task func()) error {
+	l.taskLock.Lock()
+	defer l.taskLock.Unlock()
+	if l.stopped {
+		return ErrEventLoopStopped
+	}
+	l.tasks = append(l.tasks, task)
+	return l.poller.Trigger()
+}
+
+func (l *eventLoop) stop() {
+	l.taskLock.Lock()
+	if !l.stopped {
+		l.stopped = true
+		_ = l.poller.Trigger()
+	}
+	l.taskLock.Unlock()
+}
+
+func (l *eventLoop) run() {
+	_ = l.poller.Polling(l.handleEvent)
+
+	atomic.StoreInt32(&l.busy, 1)
+	l.taskLock.Lock()
+	l.stopped = true
+	tasks := l.tasks
+	l.tasks = nil
+	l.taskLock.Unlock()
+
+	for _, task := range tasks {
+		task()
+	}
+	for _, s := range l.sessions {
+		s.Close(ErrEventLoopStopped)
+		s.finish()
+	}
+
+	l.taskLock.Lock()
+	_ = l.poller.Close()
+	l.taskLock.Unlock()
+}
+
+func (l *eventLoop) handleEvent(fd int, ev poller.Event) error {
+	atomic.StoreInt32(&l.busy, 1)
+	defer atomic.StoreInt32(&l.busy, 0)
+	if fd < 0 {
+		return l.runTasks()
+	}
+	if s, ok := l.sessions[fd]; ok {
+		s.handleEvent(ev, l.buffer)
+	}
+	return nil
+}
+
+func (l *eventLoop) runTasks() error {
+	l.taskLock.Lock()
+	tasks, stopped := l.tasks, l.stopped
+	l.tasks = nil
+	l.taskLock.Unlock()
+
+	for _, task := range tasks {
+		task()
+	}
+	if stopped {
+		return ErrEventLoopStopped
+	}
+	return nil
+}
+
+// dispatching reports whether the event loop is handling events or running tasks,
+// the callbacks of its sessions are only called in this state.
+func (l *eventLoop) dispatching() bool {
+	return atomic.LoadInt32(&l.busy) != 0
+}
+
+// attach registers the session to the event loop
+func (l *eventLoop) attach(s *EventLoopTCPSession) error {
+	return l.execute(func() {
+		if err := l.poller.AddRead(s.fd); err != nil {
+			s.fail(err)
+			return
+		}
+		l.sessions[s.fd] = s
+	})
+}
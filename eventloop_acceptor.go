@@ -0,0 +1,196 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package dnet
+
+import (
+	"context"
+	"errors"
+	"github.com/yddeng/dnet/poller"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EventLoopTCPAcceptor registers the listening socket in a poller and accepts in one goroutine.
+// OnConnection is invoked in a new goroutine like TCPAcceptor, the accepting goroutine doesn't wait for it.
+// A session built by NewEventLoopTCPSession on the accepted conn is attached to the group of the acceptor.
+// The connection limits and IPFilter of AcceptorOptions are applied, the other options are not supported.
+type EventLoopTCPAcceptor struct {
+	address  string
+	group    *EventLoopGroup
+	listener net.Listener
+	poller   *poller.Poller
+	lock     sync.Mutex
+	tracker  *connTracker
+	started  int32
+}
+
+// eventLoopConn is the conn accepted by EventLoopTCPAcceptor
+type eventLoopConn struct {
+	*net.TCPConn
+	group *EventLoopGroup
+	slot  *connSlot
+}
+
+// NewEventLoopTCPAcceptor returns a new instance of EventLoopTCPAcceptor.
+// Sessions are spread over the event loops of group, nil means DefaultEventLoopGroup.
+func NewEventLoopTCPAcceptor(address string, group *EventLoopGroup, options ...AcceptorOption) *EventLoopTCPAcceptor {
+	return &EventLoopTCPAcceptor{address: address, group: group, tracker: newConnTracker(loadAcceptorOptions(options...))}
+}
+
+// Serve listens and serve in the specified addr
+func (this *EventLoopTCPAcceptor) Serve(handler AcceptorHandler) error {
+	if handler == nil {
+		return errors.New("dnet:Serve handler is nil. ")
+	}
+
+	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
+		return errors.New("dnet:Serve acceptor is already started. ")
+	}
+
+	if this.group == nil {
+		group, err := DefaultEventLoopGroup()
+		if err != nil {
+			return err
+		}
+		this.group = group
+	}
+
+	p, err := poller.OpenPoller()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	listener, err := net.Listen("tcp", this.address)
+	if err != nil {
+		return err
+	}
+	this.lock.Lock()
+	this.listener = listener
+	this.lock.Unlock()
+	defer this.Stop()
+
+	lfd, err := detachFd(listener)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(lfd)
+
+	if err = p.AddRead(lfd); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	if atomic.LoadInt32(&this.started) == 0 {
+		this.lock.Unlock()
+		return io.EOF
+	}
+	this.poller = p
+	this.lock.Unlock()
+
+	return p.Polling(func(fd int, ev poller.Event) error {
+		if fd < 0 {
+			if atomic.LoadInt32(&this.started) == 0 {
+				return io.EOF
+			}
+			return nil
+		}
+		this.accept(lfd, handler)
+		return nil
+	})
+}
+
+// accept accepts all pending connections
+func (this *EventLoopTCPAcceptor) accept(lfd int, handler AcceptorHandler) {
+	for {
+		fd, _, err := syscall.Accept(lfd)
+		if err != nil {
+			switch err {
+			case syscall.EAGAIN:
+			case syscall.EINTR, syscall.ECONNABORTED:
+				continue
+			default:
+				// 如 too many open files，稍后重试
+				log.Printf("dnet:Serve accept failed, %s\n", err.Error())
+				time.Sleep(5 * time.Millisecond)
+			}
+			return
+		}
+		syscall.CloseOnExec(fd)
+
+		f := os.NewFile(uintptr(fd), "")
+		conn, err := net.FileConn(f)
+		_ = f.Close()
+		if err != nil {
+			log.Printf("dnet:Serve accept failed, %s\n", err.Error())
+			continue
+		}
+
+		ip := remoteIP(conn.RemoteAddr())
+		if err = this.tracker.acquire(ip); err != nil {
+			go this.tracker.refuse(conn, err)
+			continue
+		}
+		slot := &connSlot{tracker: this.tracker, ip: ip}
+		go handler.OnConnection(&eventLoopConn{TCPConn: conn.(*net.TCPConn), group: this.group, slot: slot})
+	}
+}
+
+// Close closes the connection and releases its count
+func (c *eventLoopConn) Close() error {
+	err := c.TCPConn.Close()
+	c.slot.release()
+	return err
+}
+
+// ServeFunc listens and serve in the specified addr
+func (this *EventLoopTCPAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
+	return this.Serve(handler)
+}
+
+// Addr returns the addr the acceptor will listen on
+func (this *EventLoopTCPAcceptor) Addr() net.Addr {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.listener == nil {
+		return nil
+	}
+	return this.listener.Addr()
+}
+
+// Stop stops the acceptor
+func (this *EventLoopTCPAcceptor) Stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if atomic.CompareAndSwapInt32(&this.started, 1, 0) {
+		if this.poller != nil {
+			_ = this.poller.Trigger()
+		}
+	}
+}
+
+// Connections returns the number of connections
+func (this *EventLoopTCPAcceptor) Connections() int {
+	n, _ := this.tracker.count("")
+	return n
+}
+
+// ConnectionsByIP returns the number of connections from ip
+func (this *EventLoopTCPAcceptor) ConnectionsByIP(ip string) int {
+	_, n := this.tracker.count(ip)
+	return n
+}
+
+// Shutdown stops the acceptor, and closes the sessions built on the accepted connections
+// after their output is written. The sessions still open when ctx is done are force closed.
+func (this *EventLoopTCPAcceptor) Shutdown(ctx context.Context) error {
+	this.Stop()
+	return this.tracker.shutdown(ctx)
+}
@@ -0,0 +1,769 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package dnet
+
+import (
+	"context"
+	"github.com/yddeng/dnet/poller"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EventLoopTCPSession is a TCPSession driven by an event loop.
+// It does non-blocking reads and writes on the raw fd and owns no goroutine,
+// MsgCallback is invoked in the event loop goroutine and must not block.
+//
+// Codec.Decode is called on the buffered bytes, when it runs out of data the
+// frame is taken as incomplete and decoded again after more bytes arrive.
+// So the codec must not keep state between calls, DefTCPCodec works as is.
+//
+// The encoded messages are appended to one output buffer, so OverflowDropOldest and
+// OverflowCoalesce are not supported and work as OverflowReject.
+// The event loop can't wait, so RateLimitDelay works as RateLimitDrop, and Send with BlockSend or
+// SendContext returns ErrSendChanFull instead of waiting if it is called in the event loop goroutine,
+// such as in MsgCallback.
+type EventLoopTCPSession struct {
+	id         uint64
+	opts       *Options
+	msgHandler InboundHandler // 经过拦截器的 MsgCallback
+	loop       *eventLoop
+	fd         int
+	slot       *connSlot // 接收此连接的 acceptor 的计数
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	context interface{} // 用户数据
+	ctxLock sync.Mutex
+
+	inbound []byte         // 未解码的数据，只在事件循环中访问
+	limiter *rateLimiter   // 入站限流
+	reader  inboundReader  // 解码用的 reader
+	timer   *time.Timer    // 读超时
+	hbLock  sync.Mutex     // 保护 hbTimer
+	hbTimer *time.Timer    // 心跳
+	recvAt  int64          // 最后收到消息的时间
+	wTimer  *time.Timer    // 写超时
+	wLock   sync.Mutex     // 保护以下发送相关字段
+	wCond   *sync.Cond     // 发送队列满时等待
+	output  []byte         // 待发送的数据
+	ends    []uint64       // 待发送的每条消息的结束位置
+	pending []sendCallback // 等待写完的回调，按结束位置排列
+	done    []func(error)  // 已写完待调用的回调
+	queued  uint64         // 累计入队字节数
+	sent    uint64         // 累计发送字节数
+	writing bool           // 是否注册了可写事件
+	fdClose bool           // fd 是否已经关闭
+	wErr    error          // 写错误
+
+	closed   int32
+	broken   int32 // 写出错，关闭时不再等待发送完毕
+	finished int32
+	reason   error
+	chClose  chan struct{}
+}
+
+// NewEventLoopTCPSession return an initialized *EventLoopTCPSession attached to DefaultEventLoopGroup,
+// or to the group of the EventLoopTCPAcceptor which accepted the conn.
+// The conn must implement syscall.Conn, its fd is detached and conn is closed.
+func NewEventLoopTCPSession(conn net.Conn, options ...Option) (*EventLoopTCPSession, error) {
+	if c, ok := conn.(*eventLoopConn); ok {
+		return c.group.NewTCPSession(c, options...)
+	}
+	group, err := DefaultEventLoopGroup()
+	if err != nil {
+		return nil, err
+	}
+	return group.NewTCPSession(conn, options...)
+}
+
+// NewTCPSession return an initialized *EventLoopTCPSession attached to one of the event loops.
+func (this *EventLoopGroup) NewTCPSession(conn net.Conn, options ...Option) (*EventLoopTCPSession, error) {
+	op := loadOptions(options...)
+	if op.MsgCallback == nil {
+		// need message callback
+		panic(ErrNilMsgCallBack)
+	}
+	// init default codec
+	if op.Codec == nil {
+		op.Codec = DefTCPCodec{}
+	}
+	if op.SendChannelSize <= 0 {
+		op.SendChannelSize = defSendChannelSize
+	}
+
+	var slot *connSlot
+	if c, ok := conn.(*eventLoopConn); ok {
+		conn, slot = c.TCPConn, c.slot
+	} else {
+		// 其他接收器的连接，会话关闭时释放计数
+		slot, conn = slotOf(conn)
+	}
+	localAddr, remoteAddr := conn.LocalAddr(), conn.RemoteAddr()
+	fd, err := detachFd(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &EventLoopTCPSession{
+		id:         nextSessionID(),
+		opts:       op,
+		msgHandler: chainInbound(op.InboundInterceptors, op.MsgCallback),
+		loop:       this.pick(),
+		fd:         fd,
+		slot:       slot,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		chClose:    make(chan struct{}),
+	}
+	session.wCond = sync.NewCond(&session.wLock)
+	session.limiter = newRateLimiter(op, nil)
+
+	if op.ReadTimeout > 0 {
+		session.timer = time.AfterFunc(op.ReadTimeout, session.readTimeout)
+	}
+
+	// 加入 hub 后再注册，关闭时一定能移除
+	if op.Hub != nil {
+		op.Hub.Add(session)
+	}
+	if err = session.loop.attach(session); err != nil {
+		if op.Hub != nil {
+			op.Hub.Remove(session)
+		}
+		atomic.AddInt32(&session.loop.conns, -1)
+		if session.timer != nil {
+			session.timer.Stop()
+		}
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	if slot != nil {
+		slot.add(session)
+	}
+	session.startHeartbeat()
+	return session, nil
+}
+
+// detachFd duplicates the fd of conn in non-blocking mode and closes conn.
+func detachFd(conn io.Closer) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, ErrNotSyscallConn
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	fd := -1
+	var dupErr error
+	if err = raw.Control(func(s uintptr) {
+		fd, dupErr = syscall.Dup(int(s))
+	}); err != nil {
+		return -1, err
+	}
+	if dupErr != nil {
+		return -1, dupErr
+	}
+
+	syscall.CloseOnExec(fd)
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return -1, err
+	}
+	_ = conn.Close()
+	return fd, nil
+}
+
+func (this *EventLoopTCPSession) ID() uint64 {
+	return this.id
+}
+
+func (this *EventLoopTCPSession) SetContext(context interface{}) {
+	this.ctxLock.Lock()
+	this.context = context
+	this.ctxLock.Unlock()
+}
+
+func (this *EventLoopTCPSession) Context() interface{} {
+	this.ctxLock.Lock()
+	defer this.ctxLock.Unlock()
+	return this.context
+}
+
+func (this *EventLoopTCPSession) IsClosed() bool {
+	select {
+	case <-this.chClose:
+		return true
+	default:
+		return false
+	}
+}
+
+// NetConn returns the fd of the session
+func (this *EventLoopTCPSession) NetConn() interface{} {
+	return this.fd
+}
+
+func (this *EventLoopTCPSession) LocalAddr() net.Addr {
+	return this.localAddr
+}
+
+// 对端地址
+func (this *EventLoopTCPSession) RemoteAddr() net.Addr {
+	return this.remoteAddr
+}
+
+// handleEvent 由事件循环调用
+func (this *EventLoopTCPSession) handleEvent(ev poller.Event, buffer []byte) {
+	if ev&poller.EventWrite != 0 {
+		this.handleWrite()
+	}
+	if ev&(poller.EventRead|poller.EventErr) != 0 {
+		this.handleRead(buffer)
+	}
+}
+
+func (this *EventLoopTCPSession) handleRead(buffer []byte) {
+	for {
+		n, err := syscall.Read(this.fd, buffer)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				break
+			}
+			this.readError(err)
+			return
+		}
+		if n == 0 {
+			this.readError(io.EOF)
+			return
+		}
+		if !this.IsClosed() {
+			this.inbound = append(this.inbound, buffer[:n]...)
+		}
+		if n < len(buffer) {
+			break
+		}
+	}
+
+	if this.IsClosed() {
+		// 关闭后不再处理收到的消息
+		this.inbound = nil
+		return
+	}
+	if this.timer != nil {
+		this.timer.Reset(this.opts.ReadTimeout)
+	}
+	this.decode()
+}
+
+func (this *EventLoopTCPSession) decode() {
+	// 缓存不足上次 Decode 要求的长度时不重新解码
+	for len(this.inbound) > 0 && len(this.inbound) >= this.reader.want && !this.IsClosed() {
+		this.reader.reset(this.inbound)
+		msg, err := this.opts.Codec.Decode(&this.reader)
+		if err != nil {
+			if this.reader.short {
+				// 不完整的消息，等待更多数据
+				break
+			}
+			this.readError(err)
+			return
+		}
+		this.reader.want = 0
+		if this.reader.off == 0 {
+			break
+		}
+
+		this.inbound = this.inbound[this.reader.off:]
+		if msg != nil {
+			if this.opts.HeartbeatInterval > 0 {
+				atomic.StoreInt64(&this.recvAt, time.Now().UnixNano())
+			}
+			if this.limit(this.reader.off) && !this.handleHeartbeat(msg) {
+				this.msgHandler(this, msg)
+			}
+		}
+	}
+
+	if len(this.inbound) == 0 {
+		this.inbound = nil
+	} else if cap(this.inbound) > defEventLoopBufferSize && cap(this.inbound) > 4*len(this.inbound) {
+		this.inbound = append([]byte(nil), this.inbound...)
+	}
+	this.reader.reset(nil)
+}
+
+// limit applies the rate limit to the message of n bytes, it returns false if the message is dropped.
+func (this *EventLoopTCPSession) limit(n int) bool {
+	if this.limiter == nil {
+		return true
+	}
+	if _, exceeded := this.limiter.limit(n, false); !exceeded {
+		return true
+	}
+	if this.opts.ErrorCallback != nil {
+		this.opts.ErrorCallback(this, ErrRateLimited)
+	}
+	if this.limiter.action == RateLimitClose {
+		this.Close(ErrRateLimited)
+	}
+	return false
+}
+
+func (this *EventLoopTCPSession) readError(err error) {
+	this.inbound = nil
+	if !this.IsClosed() {
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, err)
+		}
+		this.Close(err)
+	}
+}
+
+func (this *EventLoopTCPSession) readTimeout() {
+	if !this.IsClosed() {
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, ErrReadTimeout)
+		}
+		this.Close(ErrReadTimeout)
+	}
+}
+
+// startHeartbeat starts the heartbeat timer if HeartbeatInterval is set
+func (this *EventLoopTCPSession) startHeartbeat() {
+	if this.opts.HeartbeatInterval <= 0 {
+		return
+	}
+	if _, ok := this.opts.Codec.(HeartbeatCodec); !ok {
+		// 无法发送 ping，空闲的连接会被当作超时关闭
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, ErrHeartbeatUnsupported)
+		}
+		return
+	}
+	if this.opts.HeartbeatTimeout <= 0 {
+		this.opts.HeartbeatTimeout = this.opts.HeartbeatInterval
+	}
+
+	atomic.StoreInt64(&this.recvAt, time.Now().UnixNano())
+	this.hbLock.Lock()
+	this.hbTimer = time.AfterFunc(this.opts.HeartbeatInterval, this.heartbeat)
+	this.hbLock.Unlock()
+}
+
+func (this *EventLoopTCPSession) heartbeat() {
+	if this.IsClosed() {
+		return
+	}
+
+	interval, timeout := this.opts.HeartbeatInterval, this.opts.HeartbeatTimeout
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&this.recvAt)))
+	if idle >= interval+timeout {
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, ErrHeartbeatTimeout)
+		}
+		this.Close(ErrHeartbeatTimeout)
+		return
+	}
+
+	next := interval - idle
+	if idle >= interval {
+		_ = this.enqueue(nil, this.opts.Codec.(HeartbeatCodec).Ping(), nil)
+		next = interval + timeout - idle
+	}
+	this.hbLock.Lock()
+	this.hbTimer.Reset(next)
+	this.hbLock.Unlock()
+}
+
+// handleHeartbeat replies the ping and consumes the ping/pong messages,
+// it returns true if msg is consumed.
+func (this *EventLoopTCPSession) handleHeartbeat(msg interface{}) bool {
+	codec, ok := this.opts.Codec.(HeartbeatCodec)
+	if !ok {
+		return false
+	}
+	if codec.IsPing(msg) {
+		_ = this.enqueue(nil, codec.Pong(), nil)
+		return true
+	}
+	return codec.IsPong(msg)
+}
+
+func (this *EventLoopTCPSession) handleWrite() {
+	this.wLock.Lock()
+	err := this.flush()
+	done := len(this.output) == 0
+	callbacks := this.takeDone()
+	this.wLock.Unlock()
+
+	for _, fn := range callbacks {
+		fn(nil)
+	}
+	if err != nil {
+		this.fail(err)
+	} else if done && this.IsClosed() {
+		this.finish()
+	}
+}
+
+// flush writes the output as much as possible, wLock must be held.
+func (this *EventLoopTCPSession) flush() error {
+	if this.fdClose {
+		return nil
+	}
+
+	for len(this.output) > 0 {
+		n, err := syscall.Write(this.fd, this.output)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				break
+			}
+			return err
+		}
+		this.output = this.output[n:]
+		this.sent += uint64(n)
+	}
+
+	i := 0
+	for i < len(this.ends) && this.ends[i] <= this.sent {
+		i++
+	}
+	if i > 0 {
+		this.ends = this.ends[i:]
+		this.wCond.Broadcast()
+	}
+	i = 0
+	for i < len(this.pending) && this.pending[i].end <= this.sent {
+		this.done = append(this.done, this.pending[i].fn)
+		i++
+	}
+	if i > 0 {
+		this.pending = this.pending[i:]
+	}
+
+	if len(this.output) == 0 {
+		this.output, this.ends = nil, nil
+		if this.writing {
+			this.writing = false
+			if this.wTimer != nil {
+				this.wTimer.Stop()
+			}
+			return this.loop.poller.ModRead(this.fd)
+		}
+	} else if !this.writing {
+		this.writing = true
+		if this.opts.WriteTimeout > 0 {
+			if this.wTimer == nil {
+				this.wTimer = time.AfterFunc(this.opts.WriteTimeout, this.writeTimeout)
+			} else {
+				this.wTimer.Reset(this.opts.WriteTimeout)
+			}
+		}
+		return this.loop.poller.ModReadWrite(this.fd)
+	}
+	return nil
+}
+
+// takeDone returns the callbacks of the written messages, wLock must be held.
+func (this *EventLoopTCPSession) takeDone() []func(error) {
+	callbacks := this.done
+	this.done = nil
+	return callbacks
+}
+
+func (this *EventLoopTCPSession) writeTimeout() {
+	this.wLock.Lock()
+	writing := this.writing
+	this.wLock.Unlock()
+	if writing {
+		this.fail(ErrSendTimeout)
+	}
+}
+
+// fail 写出错，不再等待发送完毕
+func (this *EventLoopTCPSession) fail(err error) {
+	this.wLock.Lock()
+	if this.wErr == nil {
+		this.wErr = err
+	}
+	this.wLock.Unlock()
+	atomic.StoreInt32(&this.broken, 1)
+	if !this.IsClosed() {
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, err)
+		}
+		this.Close(err)
+	} else {
+		_ = this.loop.execute(this.tryFinish)
+	}
+}
+
+func (this *EventLoopTCPSession) Send(o interface{}) error {
+	return this.send(nil, o, nil)
+}
+
+// SendContext sends the message, it waits for the space of the send queue until ctx is done.
+func (this *EventLoopTCPSession) SendContext(ctx context.Context, o interface{}) error {
+	return this.send(ctx, o, nil)
+}
+
+// SendCallback sends the message, callback is called with nil after the message is written to the connection,
+// or with the error if it fails to be written. callback is not called if SendCallback returns an error.
+func (this *EventLoopTCPSession) SendCallback(o interface{}, callback func(err error)) error {
+	return this.send(nil, o, callback)
+}
+
+// send passes the message through OutboundInterceptors, and encodes it to the output
+func (this *EventLoopTCPSession) send(ctx context.Context, o interface{}, callback func(err error)) error {
+	return outbound(this.opts.OutboundInterceptors, this, o, func(_ Session, o interface{}) error {
+		return this.enqueue(ctx, o, callback)
+	})
+}
+
+func (this *EventLoopTCPSession) enqueue(ctx context.Context, o interface{}, callback func(err error)) error {
+	if o == nil {
+		return ErrSendMsgNil
+	}
+
+	if this.IsClosed() {
+		return ErrSessionClosed
+	}
+
+	// 没有帧类型和通道，只发送被包装的消息
+	msg, _ := unwrapMessage(o)
+	data, err := this.opts.Codec.Encode(msg)
+	if err != nil {
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, err)
+		}
+		this.Close(err)
+		return err
+	}
+	return this.write(ctx, data, callback)
+}
+
+func (this *EventLoopTCPSession) codec() Codec {
+	if len(this.opts.OutboundInterceptors) != 0 {
+		return nil
+	}
+	return this.opts.Codec
+}
+
+func (this *EventLoopTCPSession) sendEncoded(o interface{}, data []byte) error {
+	if this.IsClosed() {
+		return ErrSessionClosed
+	}
+	return this.write(nil, data, nil)
+}
+
+// sendCallback is the callback of a message waiting to be written
+type sendCallback struct {
+	end uint64 // 消息的结束位置
+	fn  func(err error)
+}
+
+// write appends data to the output.
+// It waits for the space until ctx is done if ctx is not nil, or else it follows BlockSend.
+func (this *EventLoopTCPSession) write(ctx context.Context, data []byte, callback func(err error)) (err error) {
+	if len(data) == 0 {
+		if callback != nil {
+			callback(nil)
+		}
+		return nil
+	}
+
+	block := this.opts.BlockSend || ctx != nil
+	var stop chan struct{}
+	defer func() {
+		if stop != nil {
+			close(stop)
+		}
+	}()
+
+	this.wLock.Lock()
+	for !this.fdClose && len(this.ends) >= this.opts.SendChannelSize {
+		if this.opts.OverflowPolicy == OverflowClose {
+			this.wLock.Unlock()
+			this.fail(ErrSlowConsumer)
+			return ErrSlowConsumer
+		}
+		if !block || this.loop.dispatching() {
+			// 回调中等待会阻塞事件循环，空位永远不会出现。
+			// 无法区分调用者，事件循环忙碌时其它协程也不等待
+			this.wLock.Unlock()
+			return ErrSendChanFull
+		}
+		if ctx != nil {
+			if ctx.Err() != nil {
+				this.wLock.Unlock()
+				return ctx.Err()
+			}
+			if stop == nil && ctx.Done() != nil {
+				// 开始等待，ctx 结束时唤醒
+				stop = make(chan struct{})
+				go this.wakeOnDone(ctx, stop)
+			}
+		}
+		this.wCond.Wait()
+	}
+	if this.fdClose {
+		this.wLock.Unlock()
+		return ErrSessionClosed
+	}
+
+	this.output = append(this.output, data...)
+	this.queued += uint64(len(data))
+	this.ends = append(this.ends, this.queued)
+	if callback != nil {
+		this.pending = append(this.pending, sendCallback{end: this.queued, fn: callback})
+	}
+	if !this.writing {
+		// 没有积压时直接发送
+		err = this.flush()
+	}
+	callbacks := this.takeDone()
+	this.wLock.Unlock()
+
+	for _, fn := range callbacks {
+		fn(nil)
+	}
+	if err != nil {
+		this.fail(err)
+	}
+	return nil
+}
+
+// forceClose closes the fd at once without waiting for the output,
+// reason replaces the one given to Close.
+func (this *EventLoopTCPSession) forceClose(reason error) {
+	atomic.StoreInt32(&this.broken, 1)
+	this.Close(reason)
+	_ = this.loop.execute(func() {
+		this.reason = reason
+		this.finish()
+	})
+}
+
+// wakeOnDone wakes up the senders waiting for the space when ctx is done, until stop is closed.
+func (this *EventLoopTCPSession) wakeOnDone(ctx context.Context, stop chan struct{}) {
+	select {
+	case <-ctx.Done():
+		this.wLock.Lock()
+		this.wCond.Broadcast()
+		this.wLock.Unlock()
+	case <-stop:
+	}
+}
+
+/*
+主动关闭连接
+先关闭读，待写发送完毕关闭写
+*/
+func (this *EventLoopTCPSession) Close(reason error) {
+	if atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		this.reason = reason
+		close(this.chClose)
+		_ = this.loop.execute(this.tryFinish)
+	}
+}
+
+func (this *EventLoopTCPSession) tryFinish() {
+	this.wLock.Lock()
+	done := len(this.output) == 0
+	this.wLock.Unlock()
+	if done || atomic.LoadInt32(&this.broken) == 1 {
+		this.finish()
+	}
+}
+
+// finish 关闭 fd，只在事件循环中调用
+func (this *EventLoopTCPSession) finish() {
+	if !atomic.CompareAndSwapInt32(&this.finished, 0, 1) {
+		return
+	}
+
+	this.wLock.Lock()
+	this.fdClose = true
+	_ = this.loop.poller.Delete(this.fd)
+	_ = syscall.Close(this.fd)
+	this.output, this.ends = nil, nil
+	pending, err := this.pending, this.wErr
+	this.pending = nil
+	if err == nil {
+		err = ErrSessionClosed
+	}
+	this.wCond.Broadcast()
+	this.wLock.Unlock()
+
+	// 未写完的消息
+	for _, c := range pending {
+		c.fn(err)
+	}
+
+	if this.loop.sessions[this.fd] == this {
+		delete(this.loop.sessions, this.fd)
+	}
+	atomic.AddInt32(&this.loop.conns, -1)
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+	if this.wTimer != nil {
+		this.wTimer.Stop()
+	}
+	this.hbLock.Lock()
+	if this.hbTimer != nil {
+		this.hbTimer.Stop()
+	}
+	this.hbLock.Unlock()
+	this.inbound = nil
+
+	if this.opts.Hub != nil {
+		this.opts.Hub.Remove(this)
+	}
+	if this.opts.CloseCallback != nil {
+		this.opts.CloseCallback(this, this.reason)
+	}
+	if this.slot != nil {
+		this.slot.remove(this)
+	}
+}
+
+// inboundReader reads the buffered bytes, and marks short when Decode wants more.
+// want keeps the length the short Read asked for, the buffer is decoded again once it has that many bytes.
+type inboundReader struct {
+	buf   []byte
+	off   int
+	want  int
+	short bool
+}
+
+func (r *inboundReader) reset(buf []byte) {
+	r.buf, r.off, r.short = buf, 0, false
+}
+
+func (r *inboundReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if r.off >= len(r.buf) {
+		r.short, r.want = true, r.off+len(b)
+		return 0, io.EOF
+	}
+	n := copy(b, r.buf[r.off:])
+	r.off += n
+	return n, nil
+}
@@ -0,0 +1,579 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package dnet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewEventLoopTCPSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	serverClosed := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, err = NewEventLoopTCPSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {
+				// echo
+				_ = session.Send(message)
+			}),
+			WithCloseCallback(func(session Session, reason error) {
+				serverClosed <- reason
+			}))
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn, err := DialTCP(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 100
+	// 大消息会被拆成多次读写
+	payload := bytes.Repeat([]byte{7}, 60000)
+	recv := make(chan []byte, count)
+	session := NewTCPSession(conn,
+		WithMessageCallback(func(session Session, message interface{}) {
+			recv <- message.([]byte)
+		}))
+
+	for i := 0; i < count; i++ {
+		if err := session.Send(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-recv:
+			if !bytes.Equal(msg, payload) {
+				t.Fatalf("message %d mismatch, len %d", i, len(msg))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("echo timeout, got %d", i)
+		}
+	}
+
+	_ = conn.Close()
+	select {
+	case <-serverClosed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server session not closed")
+	}
+}
+
+func TestEventLoopTCPAcceptor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	group, err := NewEventLoopGroup(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.Stop()
+	group.SetLoadBalance(LeastConnections)
+
+	acceptor := NewEventLoopTCPAcceptor(address, group)
+	if addr := acceptor.Addr(); addr != nil {
+		t.Fatalf("addr %v before serve", addr)
+	}
+	// 第一个连接的 OnConnection 阻塞，不影响接收其他连接
+	var accepted int32
+	block := make(chan struct{})
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- acceptor.ServeFunc(func(conn net.Conn) {
+			if atomic.AddInt32(&accepted, 1) == 1 {
+				<-block
+			}
+			if _, err := NewEventLoopTCPSession(conn,
+				WithMessageCallback(func(session Session, message interface{}) {
+					_ = session.Send(message)
+				})); err != nil {
+				t.Error(err)
+			}
+		})
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	const clients = 4
+	recv := make(chan []byte, clients)
+	for i := 0; i < clients; i++ {
+		conn, err := DialTCP(address, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			recv <- message.([]byte)
+		}))
+		_ = session.Send([]byte{byte(i)})
+		if i == 0 {
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+	for i := 0; i < clients; i++ {
+		if i == clients-1 {
+			close(block)
+		}
+		select {
+		case <-recv:
+		case <-time.After(5 * time.Second):
+			t.Fatal("echo timeout")
+		}
+	}
+	if addr := acceptor.Addr(); addr == nil || addr.String() != address {
+		t.Fatalf("addr %v, want %s", addr, address)
+	}
+
+	if n := group.Connections(); n != clients {
+		t.Fatalf("group connections %d, want %d", n, clients)
+	}
+	for _, loop := range group.loops {
+		if n := atomic.LoadInt32(&loop.conns); n != clients/2 {
+			t.Fatalf("loop connections %d, want %d", n, clients/2)
+		}
+	}
+
+	acceptor.Stop()
+	select {
+	case err := <-serveErr:
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acceptor not stopped")
+	}
+}
+
+func TestEventLoopTCPSessionBlockSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		// 对端不读，写满 socket 缓冲后队列满，回调中不能等待
+		payload := bytes.Repeat([]byte{7}, 60000)
+		_, _ = NewEventLoopTCPSession(conn,
+			WithBlockSend(true),
+			WithSendChannelSize(1),
+			WithMessageCallback(func(session Session, message interface{}) {
+				for {
+					if err := session.Send(payload); err != nil {
+						result <- err
+						return
+					}
+				}
+			}))
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, _ := DefTCPCodec{}.Encode([]byte("hi"))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		if err != ErrSendChanFull {
+			t.Fatalf("send in the event loop %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked the event loop")
+	}
+}
+
+func TestEventLoopTCPSessionHeartbeat(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	reasons := make(chan error, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = NewEventLoopTCPSession(conn,
+				WithCodec(pingCodec{}),
+				WithHeartbeat(50*time.Millisecond, 50*time.Millisecond),
+				WithMessageCallback(func(session Session, message interface{}) {}),
+				WithCloseCallback(func(session Session, reason error) {
+					reasons <- reason
+				}))
+		}
+	}()
+
+	// 回复 pong 的客户端
+	conn, err := DialTCP(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	NewTCPSession(conn,
+		WithCodec(pingCodec{}),
+		WithMessageCallback(func(session Session, message interface{}) {
+			t.Errorf("unexpected message %v", message)
+		}))
+
+	// 不回复的客户端
+	silent, err := DialTCP(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	select {
+	case reason := <-reasons:
+		if reason != ErrHeartbeatTimeout {
+			t.Fatalf("close reason %v", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("silent client not closed")
+	}
+
+	select {
+	case reason := <-reasons:
+		t.Fatalf("alive client closed, %v", reason)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestEventLoopTCPAcceptorShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	const count = 100
+	reasons := make(chan error, 1)
+	acceptor := NewEventLoopTCPAcceptor(address, nil)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		// OnConnection 返回后才建立会话
+		go func() {
+			session, err := NewEventLoopTCPSession(conn,
+				WithMessageCallback(func(session Session, message interface{}) {}),
+				WithCloseCallback(func(session Session, reason error) {
+					reasons <- reason
+				}))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i := 0; i < count; i++ {
+				_ = session.Send([]byte{byte(i)})
+			}
+		}()
+	})
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := acceptor.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-reasons; reason != ErrAcceptorShutdown {
+		t.Fatalf("close reason %v", reason)
+	}
+
+	// 关闭前输出的消息全部发出
+	for i := 0; i < count; i++ {
+		msg, err := DefTCPCodec{}.Decode(conn)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if msg.([]byte)[0] != byte(i) {
+			t.Fatalf("message %d mismatch", i)
+		}
+	}
+	if _, err := (DefTCPCodec{}).Decode(conn); err != io.EOF {
+		t.Fatalf("want EOF, got %v", err)
+	}
+}
+
+func TestEventLoopTCPAcceptorMaxConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	acceptor := NewEventLoopTCPAcceptor(address, nil, WithMaxConnections(1, 0))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		_, _ = NewEventLoopTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+	})
+	defer acceptor.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if n := acceptor.ConnectionsByIP("127.0.0.1"); n != 1 {
+		t.Fatalf("connections by ip %d, want 1", n)
+	}
+
+	// 超出上限
+	refused, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	if _, err = (DefTCPCodec{}).Decode(refused); err != io.EOF {
+		t.Fatalf("refused conn not closed, %v", err)
+	}
+
+	// 会话关闭后释放计数
+	_ = conn.Close()
+	for i := 0; acceptor.Connections() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("connections %d after close, want 0", acceptor.Connections())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventLoopTCPSessionOnTCPAcceptor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	acceptor := NewTCPAcceptor(address, WithMaxConnections(1, 0))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		if _, ok := conn.(interface{ Unwrap() net.Conn }).Unwrap().(*net.TCPConn); !ok {
+			t.Errorf("unwrap %T", conn)
+		}
+		_, err := NewEventLoopTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+		if err != nil {
+			t.Error(err)
+			_ = conn.Close()
+		}
+	})
+	defer acceptor.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, err := DefTCPCodec{}.Encode([]byte("echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := DefTCPCodec{}.Decode(conn)
+	if err != nil || string(msg.([]byte)) != "echo" {
+		t.Fatalf("echo %v %v", msg, err)
+	}
+
+	// 会话关闭后释放计数
+	_ = conn.Close()
+	for i := 0; acceptor.Connections() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("connections %d after close, want 0", acceptor.Connections())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventLoopTCPSessionSendContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		// 不读
+		defer conn.Close()
+		time.Sleep(5 * time.Second)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := NewEventLoopTCPSession(conn,
+		WithSendChannelSize(1),
+		WithMessageCallback(func(session Session, message interface{}) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close(nil)
+
+	// 有空位时直接返回，写满 socket 缓冲后等到 ctx 结束
+	payload := bytes.Repeat([]byte{7}, 60000)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for {
+		err := session.SendContext(ctx, payload)
+		if err == nil {
+			continue
+		}
+		if err != context.DeadlineExceeded {
+			t.Fatalf("SendContext %v, want %v", err, context.DeadlineExceeded)
+		}
+		break
+	}
+}
+
+func TestEventLoopTCPSessionWrappedMessage(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = NewEventLoopTCPSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {
+				// 包装的消息按原消息编码
+				_ = session.Send(WSMessage{Type: TextMessage, Message: message})
+			}))
+	}()
+
+	conn, err := DialTCP(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := make(chan []byte, 1)
+	session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		recv <- message.([]byte)
+	}))
+	defer session.Close(nil)
+
+	if err := session.Send([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recv:
+		if string(msg) != "hi" {
+			t.Fatalf("echo %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("echo timeout")
+	}
+}
+
+type decodeCountCodec struct {
+	DefTCPCodec
+	decodes int32
+}
+
+func (c *decodeCountCodec) Decode(reader io.Reader) (interface{}, error) {
+	atomic.AddInt32(&c.decodes, 1)
+	return c.DefTCPCodec.Decode(reader)
+}
+
+func TestEventLoopTCPSessionShortRead(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	codec := &decodeCountCodec{}
+	recv := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = NewEventLoopTCPSession(conn,
+			WithCodec(codec),
+			WithMessageCallback(func(session Session, message interface{}) {
+				recv <- message.([]byte)
+			}))
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := bytes.Repeat([]byte{7}, 60000)
+	data, _ := DefTCPCodec{}.Encode(payload)
+	// 分段发送，不完整的消息不应每次都重新解码
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := conn.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case msg := <-recv:
+		if !bytes.Equal(msg, payload) {
+			t.Fatalf("recv %d bytes", len(msg))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("recv timeout")
+	}
+	if n := atomic.LoadInt32(&codec.decodes); n > 3 {
+		t.Fatalf("decoded %d times", n)
+	}
+}
@@ -0,0 +1,90 @@
+package dnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// controlPinger is implemented by the connections with ping/pong control frames, such as WSConn.
+type controlPinger interface {
+	WritePing(deadline time.Time) error
+	SetPongHandler(h func())
+}
+
+// startHeartbeat starts the heartbeat timer if HeartbeatInterval is set
+func (this *session) startHeartbeat() {
+	if this.opts.HeartbeatInterval <= 0 {
+		return
+	}
+	pinger, isPinger := this.conn.(controlPinger)
+	if _, ok := this.opts.Codec.(HeartbeatCodec); !ok && !isPinger {
+		// 无法发送 ping，空闲的连接会被当作超时关闭
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, ErrHeartbeatUnsupported)
+		}
+		return
+	}
+	if this.opts.HeartbeatTimeout <= 0 {
+		this.opts.HeartbeatTimeout = this.opts.HeartbeatInterval
+	}
+
+	this.touch()
+	if isPinger {
+		pinger.SetPongHandler(this.touch)
+	}
+	this.lock.Lock()
+	this.heartbeatTimer = time.AfterFunc(this.opts.HeartbeatInterval, this.heartbeat)
+	this.lock.Unlock()
+}
+
+// touch records the time of receiving
+func (this *session) touch() {
+	atomic.StoreInt64(&this.lastRecv, time.Now().UnixNano())
+}
+
+func (this *session) heartbeat() {
+	if this.IsClosed() {
+		return
+	}
+
+	interval, timeout := this.opts.HeartbeatInterval, this.opts.HeartbeatTimeout
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&this.lastRecv)))
+	if idle >= interval+timeout {
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, ErrHeartbeatTimeout)
+		}
+		this.Close(ErrHeartbeatTimeout)
+		return
+	}
+
+	next := interval - idle
+	if idle >= interval {
+		this.ping()
+		next = interval + timeout - idle
+	}
+	this.lock.Lock()
+	this.heartbeatTimer.Reset(next)
+	this.lock.Unlock()
+}
+
+func (this *session) ping() {
+	if pinger, ok := this.conn.(controlPinger); ok {
+		_ = pinger.WritePing(time.Now().Add(this.opts.HeartbeatTimeout))
+	} else if codec, ok := this.opts.Codec.(HeartbeatCodec); ok {
+		_ = this.send(nil, outMessage{msg: codec.Ping(), prio: PriorityHigh})
+	}
+}
+
+// handleHeartbeat replies the ping and consumes the ping/pong messages,
+// it returns true if msg is consumed.
+func (this *session) handleHeartbeat(msg interface{}) bool {
+	codec, ok := this.opts.Codec.(HeartbeatCodec)
+	if !ok {
+		return false
+	}
+	if codec.IsPing(msg) {
+		_ = this.send(nil, outMessage{msg: codec.Pong(), prio: PriorityHigh})
+		return true
+	}
+	return codec.IsPong(msg)
+}
@@ -0,0 +1,154 @@
+package dnet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type pingCodec struct {
+	DefTCPCodec
+}
+
+func (pingCodec) Ping() interface{} { return []byte{0xff} }
+
+func (pingCodec) Pong() interface{} { return []byte{0xfe} }
+
+func (pingCodec) IsPing(msg interface{}) bool {
+	b := msg.([]byte)
+	return len(b) == 1 && b[0] == 0xff
+}
+
+func (pingCodec) IsPong(msg interface{}) bool {
+	b := msg.([]byte)
+	return len(b) == 1 && b[0] == 0xfe
+}
+
+func TestSessionHeartbeat(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	reasons := make(chan error, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			NewTCPSession(conn,
+				WithCodec(pingCodec{}),
+				WithHeartbeat(50*time.Millisecond, 50*time.Millisecond),
+				WithMessageCallback(func(session Session, message interface{}) {}),
+				WithCloseCallback(func(session Session, reason error) {
+					reasons <- reason
+				}))
+		}
+	}()
+
+	// 回复 pong 的客户端
+	conn, err := DialTCP(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	NewTCPSession(conn,
+		WithCodec(pingCodec{}),
+		WithMessageCallback(func(session Session, message interface{}) {
+			t.Errorf("unexpected message %v", message)
+		}))
+
+	// 不回复的客户端
+	silent, err := DialTCP(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	select {
+	case reason := <-reasons:
+		if reason != ErrHeartbeatTimeout {
+			t.Fatalf("close reason %v", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("silent client not closed")
+	}
+
+	select {
+	case reason := <-reasons:
+		t.Fatalf("alive client closed, %v", reason)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestSessionHeartbeatUnsupported(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	errs := make(chan error, 1)
+	closed := make(chan error, 1)
+	session := NewTCPSession(c1,
+		WithHeartbeat(50*time.Millisecond, 50*time.Millisecond),
+		WithMessageCallback(func(session Session, message interface{}) {}),
+		WithErrorCallback(func(session Session, err error) { errs <- err }),
+		WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+	defer session.Close(nil)
+
+	if err := <-errs; err != ErrHeartbeatUnsupported {
+		t.Fatalf("error %v, want %v", err, ErrHeartbeatUnsupported)
+	}
+	// 默认编码器不能发送 ping，空闲的连接不关闭
+	select {
+	case reason := <-closed:
+		t.Fatalf("idle session closed, %v", reason)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestWSSessionHeartbeat(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	reasons := make(chan error, 1)
+	acceptor := NewWSAcceptor(address)
+	defer acceptor.Stop()
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewWSSession(conn,
+			WithHeartbeat(50*time.Millisecond, 50*time.Millisecond),
+			WithMessageCallback(func(session Session, message interface{}) {}),
+			WithCloseCallback(func(session Session, reason error) {
+				reasons <- reason
+			}))
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := DialWS(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 读消息时自动回复 pong
+	session := NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+
+	select {
+	case reason := <-reasons:
+		t.Fatalf("alive client closed, %v", reason)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// 关闭读后不再回复 pong
+	session.Close(nil)
+	select {
+	case reason := <-reasons:
+		if reason == nil {
+			t.Fatal("want close reason")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server session not closed")
+	}
+}
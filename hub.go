@@ -0,0 +1,148 @@
+package dnet
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+var sessionID uint64
+
+// nextSessionID returns a unique id for a new session
+func nextSessionID() uint64 {
+	return atomic.AddUint64(&sessionID, 1)
+}
+
+// encodedSender is implemented by the sessions which can send encoded data,
+// Hub uses it to encode a broadcast message only once.
+// codec returns nil if the messages pass through OutboundInterceptors, they are sent by Send.
+type encodedSender interface {
+	codec() Codec
+	sendEncoded(o interface{}, data []byte) error
+}
+
+// Hub is a registry of sessions.
+// Sessions created with WithHub join the hub, and leave it after they are closed.
+type Hub struct {
+	lock     sync.RWMutex
+	sessions map[uint64]Session
+}
+
+// NewHub returns an empty hub
+func NewHub() *Hub {
+	return &Hub{sessions: map[uint64]Session{}}
+}
+
+// Add adds the session to the hub
+func (h *Hub) Add(session IDSession) {
+	h.lock.Lock()
+	h.sessions[session.ID()] = session
+	h.lock.Unlock()
+}
+
+// Remove removes the session from the hub
+func (h *Hub) Remove(session IDSession) {
+	h.lock.Lock()
+	if s, ok := h.sessions[session.ID()]; ok && s == Session(session) {
+		delete(h.sessions, session.ID())
+	}
+	h.lock.Unlock()
+}
+
+// Get returns the session with the id
+func (h *Hub) Get(id uint64) (Session, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	session, ok := h.sessions[id]
+	return session, ok
+}
+
+// Len returns the number of sessions
+func (h *Hub) Len() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.sessions)
+}
+
+// Range calls f for each session, it stops if f returns false.
+func (h *Hub) Range(f func(session Session) bool) {
+	for _, session := range h.snapshot() {
+		if !f(session) {
+			return
+		}
+	}
+}
+
+func (h *Hub) snapshot() []Session {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	sessions := make([]Session, 0, len(h.sessions))
+	for _, session := range h.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Broadcast sends the message to all sessions.
+func (h *Hub) Broadcast(o interface{}) error {
+	return h.BroadcastFilter(o, nil)
+}
+
+// BroadcastFilter sends the message to the sessions that filter returns true, nil filter means all.
+// The message is encoded once for each codec, sessions with a full send queue are skipped.
+// Sessions with OutboundInterceptors send it by Send, it passes through their interceptors.
+// The sessions of a codec failing to encode it are skipped too, it returns the first encode error
+// after the message is sent to the other sessions.
+func (h *Hub) BroadcastFilter(o interface{}, filter func(session Session) bool) error {
+	if o == nil {
+		return ErrSendMsgNil
+	}
+
+	type encoded struct {
+		codec Codec
+		data  []byte
+		err   error
+	}
+	var cache []encoded
+	var firstErr error
+
+	for _, session := range h.snapshot() {
+		if filter != nil && !filter(session) {
+			continue
+		}
+
+		sender, ok := session.(encodedSender)
+		if !ok {
+			_ = session.Send(o)
+			continue
+		}
+
+		codec := sender.codec()
+		if codec == nil || !reflect.TypeOf(codec).Comparable() {
+			// 经过拦截器或无法缓存，按会话编码
+			_ = session.Send(o)
+			continue
+		}
+
+		var data []byte
+		var err error
+		found := false
+		for _, e := range cache {
+			if e.codec == codec {
+				data, err, found = e.data, e.err, true
+				break
+			}
+		}
+		if !found {
+			msg, _ := unwrapMessage(o)
+			if data, err = codec.Encode(msg); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			cache = append(cache, encoded{codec: codec, data: data, err: err})
+		}
+		if err == nil {
+			_ = sender.sendEncoded(o, data)
+		}
+	}
+	return firstErr
+}
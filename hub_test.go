@@ -0,0 +1,191 @@
+package dnet
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countCodec struct {
+	DefTCPCodec
+	encodes int32
+}
+
+func (c *countCodec) Encode(o interface{}) ([]byte, error) {
+	atomic.AddInt32(&c.encodes, 1)
+	return c.DefTCPCodec.Encode(o)
+}
+
+func TestHub(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	hub := NewHub()
+	codec := &countCodec{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			NewTCPSession(conn,
+				WithHub(hub),
+				WithCodec(codec),
+				WithMessageCallback(func(session Session, message interface{}) {}))
+		}
+	}()
+
+	const clients = 3
+	recv := make(chan []byte, clients)
+	conns := make([]net.Conn, 0, clients)
+	for i := 0; i < clients; i++ {
+		conn, err := DialTCP(l.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			recv <- message.([]byte)
+		}))
+	}
+
+	for i := 0; hub.Len() != clients; i++ {
+		if i > 100 {
+			t.Fatalf("hub len %d, want %d", hub.Len(), clients)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hub.Range(func(session Session) bool {
+		id := session.(IDSession).ID()
+		if s, ok := hub.Get(id); !ok || s != session {
+			t.Fatalf("get session %d failed", id)
+		}
+		return true
+	})
+
+	if err := hub.Broadcast([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < clients; i++ {
+		select {
+		case msg := <-recv:
+			if len(msg) != 3 {
+				t.Fatalf("broadcast message %v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("broadcast timeout")
+		}
+	}
+	if n := atomic.LoadInt32(&codec.encodes); n != 1 {
+		t.Fatalf("encode %d times, want 1", n)
+	}
+
+	_ = conns[0].Close()
+	for i := 0; hub.Len() != clients-1; i++ {
+		if i > 100 {
+			t.Fatalf("hub len %d, want %d", hub.Len(), clients-1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type failCodec struct {
+	DefTCPCodec
+}
+
+func (failCodec) Encode(o interface{}) ([]byte, error) {
+	return nil, errors.New("encode failed")
+}
+
+func TestHubBroadcastEncodeError(t *testing.T) {
+	hub := NewHub()
+	recv := make(chan []byte, 1)
+	for _, codec := range []Codec{failCodec{}, DefTCPCodec{}} {
+		server, client := net.Pipe()
+		defer client.Close()
+		NewTCPSession(server, WithHub(hub), WithCodec(codec),
+			WithMessageCallback(func(session Session, message interface{}) {}))
+		NewTCPSession(client, WithMessageCallback(func(session Session, message interface{}) {
+			recv <- message.([]byte)
+		}))
+	}
+
+	// 编码失败的会话被跳过，其他会话仍然收到
+	if err := hub.Broadcast([]byte{1, 2, 3}); err == nil {
+		t.Fatal("broadcast without the encode error")
+	}
+	select {
+	case msg := <-recv:
+		if len(msg) != 3 {
+			t.Fatalf("broadcast message %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("broadcast timeout")
+	}
+}
+
+func TestHubBroadcastInterceptor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	hub := NewHub()
+	mark := WithOutboundInterceptor(func(session Session, message interface{}, next OutboundHandler) error {
+		return next(session, append(message.([]byte), '!'))
+	})
+	callback := WithMessageCallback(func(session Session, message interface{}) {})
+	newSessions := []func(conn net.Conn){
+		func(conn net.Conn) { NewTCPSession(conn, WithHub(hub), mark, callback) },
+		func(conn net.Conn) { _, _ = NewEventLoopTCPSession(conn, WithHub(hub), mark, callback) },
+		func(conn net.Conn) { NewTCPSession(conn, WithHub(hub), callback) },
+	}
+
+	recv := make(chan string, len(newSessions))
+	for _, newSession := range newSessions {
+		conn, err := DialTCP(l.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			recv <- string(message.([]byte))
+		}))
+		server, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		newSession(server)
+	}
+
+	if err := hub.Broadcast([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	// 有出站拦截器的会话经过拦截器发送
+	marked := 0
+	for range newSessions {
+		select {
+		case msg := <-recv:
+			switch msg {
+			case "abc!":
+				marked++
+			case "abc":
+			default:
+				t.Fatalf("broadcast message %s", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("broadcast timeout")
+		}
+	}
+	if marked != 2 {
+		t.Fatalf("%d messages pass through the interceptors, want 2", marked)
+	}
+}
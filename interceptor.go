@@ -0,0 +1,48 @@
+package dnet
+
+// InboundHandler handles a decoded message, MsgCallback is the last one.
+type InboundHandler func(session Session, message interface{})
+
+// InboundInterceptor is called with the decoded message before MsgCallback.
+// It passes the message, or a transformed one, to next, and drops it by not calling next.
+type InboundInterceptor func(session Session, message interface{}, next InboundHandler)
+
+// OutboundHandler handles a message to send, putting it in the send queue is the last one.
+type OutboundHandler func(session Session, message interface{}) error
+
+// OutboundInterceptor is called with the message passed to Send before it is queued.
+// It passes the message, or a transformed one, to next and returns its error.
+// It drops the message by returning an error, such as ErrSendDropped, without calling next.
+type OutboundInterceptor func(session Session, message interface{}, next OutboundHandler) error
+
+// chainInbound returns the handler calling the interceptors in order, and handler at last.
+// A nil message is dropped.
+func chainInbound(interceptors []InboundInterceptor, handler InboundHandler) InboundHandler {
+	next := func(session Session, message interface{}) {
+		if message != nil {
+			handler(session, message)
+		}
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, h := interceptors[i], next
+		next = func(session Session, message interface{}) {
+			if message != nil {
+				interceptor(session, message, h)
+			}
+		}
+	}
+	return next
+}
+
+// outbound calls the interceptors in order, and handler at last
+func outbound(interceptors []OutboundInterceptor, session Session, message interface{}, handler OutboundHandler) error {
+	if message == nil {
+		return ErrSendMsgNil
+	}
+	if len(interceptors) == 0 {
+		return handler(session, message)
+	}
+	return interceptors[0](session, message, func(session Session, message interface{}) error {
+		return outbound(interceptors[1:], session, message, handler)
+	})
+}
@@ -0,0 +1,119 @@
+package dnet
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterceptor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var lock sync.Mutex
+	var trace []string
+	record := func(name string) {
+		lock.Lock()
+		trace = append(trace, name)
+		lock.Unlock()
+	}
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		NewTCPSession(conn,
+			WithInboundInterceptor(func(session Session, message interface{}, next InboundHandler) {
+				record("in1")
+				next(session, message)
+			}, func(session Session, message interface{}, next InboundHandler) {
+				record("in2")
+				// 丢弃
+				if string(message.([]byte)) != "drop!" {
+					next(session, message)
+				}
+			}),
+			WithInboundInterceptor(func(session Session, message interface{}, next InboundHandler) {
+				record("in3")
+				next(session, bytes.ToUpper(message.([]byte)))
+			}),
+			WithMessageCallback(func(session Session, message interface{}) {
+				_ = session.Send(message)
+			}))
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := make(chan string, 4)
+	session := NewTCPSession(conn,
+		WithOutboundInterceptor(func(session Session, message interface{}, next OutboundHandler) error {
+			record("out1")
+			if string(message.([]byte)) == "bad" {
+				return ErrSendDropped
+			}
+			return next(session, message)
+		}, func(session Session, message interface{}, next OutboundHandler) error {
+			record("out2")
+			return next(session, append(message.([]byte), '!'))
+		}),
+		WithMessageCallback(func(session Session, message interface{}) {
+			recv <- string(message.([]byte))
+		}))
+	defer session.Close(nil)
+
+	expect := func(want string, names ...string) {
+		select {
+		case msg := <-recv:
+			if msg != want {
+				t.Fatalf("recv %s, want %s", msg, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("recv %s timeout", want)
+		}
+		// 两端的记录可能交错，分别比较入站和出站的顺序
+		filter := func(names []string, prefix string) string {
+			var s []string
+			for _, name := range names {
+				if strings.HasPrefix(name, prefix) {
+					s = append(s, name)
+				}
+			}
+			return strings.Join(s, ",")
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, prefix := range []string{"in", "out"} {
+			if filter(trace, prefix) != filter(names, prefix) {
+				t.Fatalf("trace %v, want %v", trace, names)
+			}
+		}
+		trace = nil
+	}
+
+	if err := session.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	expect("HELLO!", "out1", "out2", "in1", "in2", "in3")
+
+	if err := session.Send([]byte("bad")); err != ErrSendDropped {
+		t.Fatalf("send the rejected message %v", err)
+	}
+
+	// 入站丢弃后不回显
+	if err := session.Send([]byte("drop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Send([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	expect("NEXT!", "out1", "out1", "out2", "out1", "out2", "in1", "in2", "in1", "in2", "in3")
+}
@@ -0,0 +1,150 @@
+package dnet
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IPFilter allows or denies the connections by CIDR lists, it can be reloaded at runtime.
+// An ip is allowed if it matches no deny CIDR, and matches an allow CIDR or the allow list is empty.
+type IPFilter struct {
+	lock    sync.RWMutex
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	proxies []*net.IPNet
+}
+
+// NewIPFilter returns an IPFilter which allows all
+func NewIPFilter() *IPFilter {
+	return &IPFilter{}
+}
+
+// SetAllow replaces the allow list, an empty list allows all.
+// An element is a CIDR like "10.0.0.0/8", or an ip.
+func (f *IPFilter) SetAllow(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	f.allow = nets
+	f.lock.Unlock()
+	return nil
+}
+
+// SetDeny replaces the deny list.
+func (f *IPFilter) SetDeny(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	f.deny = nets
+	f.lock.Unlock()
+	return nil
+}
+
+// SetTrustedProxies replaces the proxies whose X-Forwarded-For header is trusted by WSAcceptor.
+func (f *IPFilter) SetTrustedProxies(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	f.proxies = nets
+	f.lock.Unlock()
+	return nil
+}
+
+// Allow reports whether the ip is allowed
+func (f *IPFilter) Allow(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if containsIP(f.deny, parsed) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, parsed)
+}
+
+// clientIP returns the ip of the client of a http request.
+// If the peer is a trusted proxy, X-Forwarded-For is walked from right to left,
+// and the first address which is not a trusted proxy is the client.
+func (f *IPFilter) clientIP(r *http.Request) string {
+	ip := hostIP(r.RemoteAddr)
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if !f.trusted(ip) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			// 无法解析，不再信任之前的地址
+			break
+		}
+		ip = addr
+		if !f.trusted(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether ip is a trusted proxy
+func (f *IPFilter) trustedProxy(ip string) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.trusted(ip)
+}
+
+// trusted reports whether ip is a trusted proxy, lock must be held.
+func (f *IPFilter) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && containsIP(f.proxies, parsed)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,123 @@
+package dnet
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter := NewIPFilter()
+	if !filter.Allow("1.2.3.4") {
+		t.Fatal("empty filter denies")
+	}
+
+	if err := filter.SetAllow("10.0.0.0/8", "192.168.1.1", "fd00::/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.SetDeny("10.1.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.SetAllow("10.0.0.0/33"); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+
+	for ip, want := range map[string]bool{
+		"10.2.3.4":    true,
+		"10.1.3.4":    false,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"fd00::1":     true,
+		"::1":         false,
+		"bad":         false,
+	} {
+		if got := filter.Allow(ip); got != want {
+			t.Fatalf("allow %s %v, want %v", ip, got, want)
+		}
+	}
+
+	if err := filter.SetTrustedProxies("127.0.0.1", "172.16.0.0/12"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"1.1.1.1:80", []string{"2.2.2.2"}, "1.1.1.1"},
+		{"127.0.0.1:80", nil, "127.0.0.1"},
+		{"127.0.0.1:80", []string{"2.2.2.2, 3.3.3.3"}, "3.3.3.3"},
+		{"127.0.0.1:80", []string{"2.2.2.2", "3.3.3.3, 172.16.0.1"}, "3.3.3.3"},
+		{"127.0.0.1:80", []string{"2.2.2.2, bad, 172.16.0.1"}, "172.16.0.1"},
+	} {
+		r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := filter.clientIP(r); got != tt.want {
+			t.Fatalf("client ip of %s %v: %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestTCPAcceptorIPFilter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	filter := NewIPFilter()
+	if err := filter.SetDeny("127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	refused := make(chan error, 1)
+	acceptor := NewTCPAcceptor(address,
+		WithIPFilter(filter),
+		WithRefuseCallback(func(conn net.Conn, reason error) { refused <- reason }))
+	accepted := make(chan struct{}, 1)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		accepted <- struct{}{}
+		conn.Close()
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 直接关闭，不调用 RefuseCallback
+	conn, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("denied conn not closed, %v", err)
+	}
+	conn.Close()
+	select {
+	case reason := <-refused:
+		t.Fatalf("refuse callback called with %v", reason)
+	case <-accepted:
+		t.Fatal("denied ip accepted")
+	default:
+	}
+
+	// 运行时重新加载
+	if err := filter.SetDeny(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err = DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case <-accepted:
+	case <-refused:
+		t.Fatal("allowed ip refused")
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept timeout")
+	}
+}
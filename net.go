@@ -1,19 +1,38 @@
 package dnet
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
 )
 
 var (
-	ErrSessionClosed  = errors.New("dnet: session is closed. ")
-	ErrNilMsgCallBack = errors.New("dnet: session without msgCallback")
-	ErrSendMsgNil     = errors.New("dnet: session send msg is nil")
-	ErrSendChanFull   = errors.New("dnet: session send channel is full")
+	ErrSessionClosed      = errors.New("dnet: session is closed. ")
+	ErrNilMsgCallBack     = errors.New("dnet: session without msgCallback")
+	ErrSendMsgNil         = errors.New("dnet: session send msg is nil")
+	ErrSendChanFull       = errors.New("dnet: session send channel is full")
+	ErrSendDropped        = errors.New("dnet: session send msg is dropped")
+	ErrInvalidPriority    = errors.New("dnet: session send priority is invalid")
+	ErrTooManyConnections = errors.New("dnet: acceptor has too many connections")
+	ErrIPDenied           = errors.New("dnet: acceptor denies the ip")
+	ErrMessageTooLarge    = errors.New("dnet: message is too large")
+	ErrRateLimited        = errors.New("dnet: session inbound rate limit exceeded")
+	ErrSlowConsumer       = errors.New("dnet: session send channel is full, slow consumer")
+	ErrNotSyscallConn     = errors.New("dnet: conn doesn't implement syscall.Conn")
 
 	ErrSendTimeout = errors.New("dnet: send timeout. ")
 	ErrReadTimeout = errors.New("dnet: read timeout. ")
+
+	ErrHeartbeatTimeout     = errors.New("dnet: heartbeat timeout. ")
+	ErrHeartbeatUnsupported = errors.New("dnet: heartbeat needs a HeartbeatCodec to send ping. ")
+
+	ErrAcceptorShutdown = errors.New("dnet: acceptor is shutting down. ")
+	ErrShutdownTimeout  = errors.New("dnet: acceptor shutdown timeout, session is force closed. ")
+
+	ErrResumeRejected = errors.New("dnet: session resumption is rejected. ")
+	ErrResumeTimeout  = errors.New("dnet: session is not resumed in time. ")
+	ErrResumeProtocol = errors.New("dnet: session resumption protocol error. ")
 )
 
 type Session interface {
@@ -42,6 +61,31 @@ type Session interface {
 	IsClosed() bool
 }
 
+// IDSession is implemented by the sessions of dnet, Hub keeps them by id
+type IDSession interface {
+	Session
+
+	// ID returns the unique id of the session
+	ID() uint64
+}
+
+// ContextSender is implemented by the sessions of dnet
+type ContextSender interface {
+	Session
+
+	// SendContext is like Send, but it waits for the space of the send queue until ctx is done
+	SendContext(ctx context.Context, o interface{}) error
+}
+
+// CallbackSender is implemented by the sessions of dnet
+type CallbackSender interface {
+	Session
+
+	// SendCallback is like Send, callback is called after the data is written to the connection,
+	// or with the error if it fails to be encoded or written
+	SendCallback(o interface{}, callback func(err error)) error
+}
+
 // AcceptorHandle type interface
 type AcceptorHandler interface {
 	// handler to invokes
@@ -83,3 +127,21 @@ type Codec interface {
 	// Decode
 	Decode(reader io.Reader) (interface{}, error)
 }
+
+// HeartbeatCodec is implemented by the codecs which define the heartbeat messages.
+// The decoded ping and pong messages are handled by session, and not passed to MsgCallback.
+type HeartbeatCodec interface {
+	Codec
+
+	// Ping returns the message sent when the session is idle
+	Ping() interface{}
+
+	// Pong returns the message replied to a ping
+	Pong() interface{}
+
+	// IsPing reports whether the decoded message is a ping
+	IsPing(msg interface{}) bool
+
+	// IsPong reports whether the decoded message is a pong
+	IsPong(msg interface{}) bool
+}
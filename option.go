@@ -31,15 +31,55 @@ type Options struct {
 	// capacity of the send channel. default net.defSendChannelSize
 	SendChannelSize int
 
+	// what to do when the send channel is full. default OverflowReject
+	OverflowPolicy OverflowPolicy
+
+	// a waiting lower priority lane gets one message written after every PriorityShare messages
+	// of the higher lanes, so it never starves. default net.defPriorityShare
+	PriorityShare int
+
+	// returns the key of the message for OverflowCoalesce, nil means the message can't be coalesced.
+	// the keys are compared with ==, a key not comparable panics.
+	CoalesceKey func(o interface{}) interface{}
+
+	// the max count of messages flushed in one write. default net.defSendBatchSize
+	SendBatchSize int
+
+	// the max bytes of messages flushed in one write, a larger message is still sent alone.
+	// default net.defSendBatchBytes
+	SendBatchBytes int
+
 	// the deadline for read
 	ReadTimeout time.Duration
 
 	// the deadline for write
 	WriteTimeout time.Duration
 
+	// send a ping when nothing is received for HeartbeatInterval,
+	// and close the session with ErrHeartbeatTimeout if still nothing is received in HeartbeatTimeout.
+	// ping is a control frame on WSConn, or HeartbeatCodec.Ping for the other connections.
+	// the heartbeat is not started if ping can't be sent, and ErrorCallback is called with ErrHeartbeatUnsupported.
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+
+	// inbound messages and bytes per second of a session, 0 means no limit.
+	// the bursts default to one second of the rates.
+	// RateLimitAction is applied when the limits are exceeded, and ErrorCallback is called with ErrRateLimited.
+	MsgRateLimit    int
+	MsgRateBurst    int
+	ByteRateLimit   int
+	ByteRateBurst   int
+	RateLimitAction RateLimitAction
+
 	// session will call the MsgCallback,if it has a message
 	MsgCallback func(session Session, message interface{})
 
+	// the decoded messages pass through InboundInterceptors in order before MsgCallback,
+	// and the messages passed to Send pass through OutboundInterceptors in order before they are queued.
+	// the heartbeats don't pass through them
+	InboundInterceptors  []InboundInterceptor
+	OutboundInterceptors []OutboundInterceptor
+
 	// session will call the ErrorCallback,if it has a error
 	ErrorCallback func(session Session, err error)
 
@@ -48,6 +88,43 @@ type Options struct {
 
 	// encoder and decoder
 	Codec Codec
+
+	// maps the reason of Close to the code and text of the close frame sent by a WebSocket session.
+	// default DefWSCloseCode
+	WSCloseCode func(reason error) (code int, text string)
+
+	// session joins the Hub when created, and leaves it after closed
+	Hub *Hub
+
+	// the backoff of ReconnectingSession redialing, it doubles from ReconnectBackoff to ReconnectMaxBackoff,
+	// and each wait is jittered in [backoff/2, backoff]. default net.defReconnectBackoff and net.defReconnectMaxBackoff
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+
+	// ReconnectingSession is closed with the dial error after ReconnectMaxAttempts dials fail in a row, 0 means no limit
+	ReconnectMaxAttempts int
+
+	// the max number of messages buffered by ReconnectingSession while it is disconnected. default SendChannelSize
+	ResendBufferSize int
+
+	// ReconnectingSession will call the ConnectCallback after it connects the first time
+	ConnectCallback func(session Session)
+
+	// ReconnectingSession and ResumableSession will call the DisconnectCallback after the connection is lost,
+	// ReconnectingSession calls it before redialing
+	DisconnectCallback func(session Session, reason error)
+
+	// ReconnectingSession will call the ReconnectCallback after it reconnects, attempts is the number of dials it took
+	ReconnectCallback func(session Session, attempts int)
+
+	// the server keeps a disconnected ResumableSession for ResumeTimeout. default net.defResumeTimeout
+	ResumeTimeout time.Duration
+
+	// the max number of messages sent by ResumableSession and not acknowledged by the peer. default SendChannelSize
+	ResumeBufferSize int
+
+	// ResumableSession will call the ResumeCallback after it is resumed with a new connection
+	ResumeCallback func(session Session)
 }
 
 // WithOptions accepts the whole options config.
@@ -71,6 +148,36 @@ func WithSendChannelSize(size int) Option {
 	}
 }
 
+// WithOverflowPolicy sets the policy applied when the send channel is full.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(opt *Options) {
+		opt.OverflowPolicy = policy
+	}
+}
+
+// WithCoalesce sets OverflowCoalesce with the key function.
+func WithCoalesce(key func(o interface{}) interface{}) Option {
+	return func(opt *Options) {
+		opt.OverflowPolicy = OverflowCoalesce
+		opt.CoalesceKey = key
+	}
+}
+
+// WithPriorityShare sets the share of the lower priority lanes.
+func WithPriorityShare(share int) Option {
+	return func(opt *Options) {
+		opt.PriorityShare = share
+	}
+}
+
+// WithSendBatch sets the max count and bytes of messages flushed in one write.
+func WithSendBatch(count, bytes int) Option {
+	return func(opt *Options) {
+		opt.SendBatchSize = count
+		opt.SendBatchBytes = bytes
+	}
+}
+
 // WithMessageCallback sets message callback.
 func WithMessageCallback(msgCb func(session Session, message interface{})) Option {
 	return func(opt *Options) {
@@ -78,6 +185,20 @@ func WithMessageCallback(msgCb func(session Session, message interface{})) Optio
 	}
 }
 
+// WithInboundInterceptor appends the inbound interceptors.
+func WithInboundInterceptor(interceptors ...InboundInterceptor) Option {
+	return func(opt *Options) {
+		opt.InboundInterceptors = append(opt.InboundInterceptors, interceptors...)
+	}
+}
+
+// WithOutboundInterceptor appends the outbound interceptors.
+func WithOutboundInterceptor(interceptors ...OutboundInterceptor) Option {
+	return func(opt *Options) {
+		opt.OutboundInterceptors = append(opt.OutboundInterceptors, interceptors...)
+	}
+}
+
 // WithErrorCallback sets error callback.
 func WithErrorCallback(errCb func(session Session, err error)) Option {
 	return func(opt *Options) {
@@ -93,6 +214,31 @@ func WithTimeout(readTimeout, writeTimeout time.Duration) Option {
 	}
 }
 
+// WithHeartbeat sets the idle interval to send ping and the timeout to wait for pong.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(opt *Options) {
+		opt.HeartbeatInterval = interval
+		opt.HeartbeatTimeout = timeout
+	}
+}
+
+// WithRateLimit sets the inbound limits of messages and bytes per second, and the action when exceeded.
+func WithRateLimit(msgs, bytes int, action RateLimitAction) Option {
+	return func(opt *Options) {
+		opt.MsgRateLimit = msgs
+		opt.ByteRateLimit = bytes
+		opt.RateLimitAction = action
+	}
+}
+
+// WithRateBurst sets the bursts of the inbound limits.
+func WithRateBurst(msgs, bytes int) Option {
+	return func(opt *Options) {
+		opt.MsgRateBurst = msgs
+		opt.ByteRateBurst = bytes
+	}
+}
+
 // WithCodec sets codec.
 func WithCodec(codec Codec) Option {
 	return func(opt *Options) {
@@ -100,9 +246,81 @@ func WithCodec(codec Codec) Option {
 	}
 }
 
+// WithWSCloseCode sets the mapper of the close code.
+func WithWSCloseCode(mapper func(reason error) (code int, text string)) Option {
+	return func(opt *Options) {
+		opt.WSCloseCode = mapper
+	}
+}
+
 // WithCloseCallback sets close callback.
 func WithCloseCallback(closeCallback func(session Session, reason error)) Option {
 	return func(opt *Options) {
 		opt.CloseCallback = closeCallback
 	}
 }
+
+// WithReconnectBackoff sets the min and max backoff of redialing.
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(opt *Options) {
+		opt.ReconnectBackoff = min
+		opt.ReconnectMaxBackoff = max
+	}
+}
+
+// WithReconnectMaxAttempts sets the max number of failed dials in a row.
+func WithReconnectMaxAttempts(attempts int) Option {
+	return func(opt *Options) {
+		opt.ReconnectMaxAttempts = attempts
+	}
+}
+
+// WithResendBufferSize sets the max number of messages buffered while disconnected.
+func WithResendBufferSize(size int) Option {
+	return func(opt *Options) {
+		opt.ResendBufferSize = size
+	}
+}
+
+// WithConnectCallback sets connect callback.
+func WithConnectCallback(connectCallback func(session Session)) Option {
+	return func(opt *Options) {
+		opt.ConnectCallback = connectCallback
+	}
+}
+
+// WithDisconnectCallback sets disconnect callback.
+func WithDisconnectCallback(disconnectCallback func(session Session, reason error)) Option {
+	return func(opt *Options) {
+		opt.DisconnectCallback = disconnectCallback
+	}
+}
+
+// WithReconnectCallback sets reconnect callback.
+func WithReconnectCallback(reconnectCallback func(session Session, attempts int)) Option {
+	return func(opt *Options) {
+		opt.ReconnectCallback = reconnectCallback
+	}
+}
+
+// WithResume sets the resume timeout and the max number of messages not acknowledged.
+func WithResume(timeout time.Duration, size int) Option {
+	return func(opt *Options) {
+		opt.ResumeTimeout = timeout
+		opt.ResumeBufferSize = size
+	}
+}
+
+// WithResumeCallback sets resume callback.
+func WithResumeCallback(resumeCallback func(session Session)) Option {
+	return func(opt *Options) {
+		opt.ResumeCallback = resumeCallback
+	}
+}
+
+// WithHub sets the hub which the session joins.
+func WithHub(hub *Hub) Option {
+	return func(opt *Options) {
+		opt.Hub = hub
+	}
+}
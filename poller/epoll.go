@@ -1,3 +1,4 @@
+//go:build linux
 // +build linux
 
 package poller
@@ -100,14 +101,19 @@ func (p *Poller) Polling(callback func(fd int, ev Event) error) (err error) {
 	var wakeUp bool
 	var event Event
 	var e syscall.EpollEvent
+	var n int
 	for {
-		n, err := syscall.EpollWait(p.fd, p.events, -1)
-		if err != nil && err != syscall.EINTR {
+		n, err = syscall.EpollWait(p.fd, p.events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
 			return err
 		}
 		for i := 0; i < n; i++ {
 			e = p.events[i]
 			if fd := int(e.Fd); fd != p.wfd {
+				event = 0
 				if e.Events&uint32(errorEvents) != 0 {
 					event |= EventErr
 				}
@@ -1,3 +1,4 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
 // +build darwin netbsd freebsd openbsd dragonfly
 
 package poller
@@ -94,9 +95,13 @@ func (p *Poller) Delete(fd int) error {
 
 func (p *Poller) Polling(callback func(fd int, ev Event) error) (err error) {
 	var wakeUp bool
+	var n int
 	for {
-		n, err := syscall.Kevent(p.fd, nil, p.events, nil)
-		if err != nil && err != syscall.EINTR {
+		n, err = syscall.Kevent(p.fd, nil, p.events, nil)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
 			return err
 		}
 
@@ -116,7 +121,7 @@ func (p *Poller) Polling(callback func(fd int, ev Event) error) (err error) {
 					event |= EventWrite
 				}
 				if err = callback(fd, event); err != nil {
-					//return
+					return
 				}
 			} else {
 				wakeUp = true
@@ -124,7 +129,7 @@ func (p *Poller) Polling(callback func(fd int, ev Event) error) (err error) {
 		}
 		if wakeUp {
 			if err = callback(-1, EventNone); err != nil {
-				//return
+				return
 			}
 			wakeUp = false
 		}
@@ -0,0 +1,160 @@
+package dnet
+
+import (
+	"io"
+	"time"
+)
+
+// RateLimitAction decides what the session does when the inbound rate limit is exceeded.
+type RateLimitAction int32
+
+const (
+	// RateLimitDelay delays reading until the tokens are refilled. It is the default.
+	RateLimitDelay RateLimitAction = iota
+	// RateLimitDrop drops the message without calling MsgCallback.
+	RateLimitDrop
+	// RateLimitClose closes the session with ErrRateLimited.
+	RateLimitClose
+)
+
+// tokenBucket is refilled with rate tokens per second, and holds burst tokens at most.
+// The tokens may be taken in advance, the debt is paid by waiting.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow takes n tokens if there are enough
+func (b *tokenBucket) allow(n int, now time.Time) bool {
+	b.refill(now)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// take takes n tokens, it returns how long to wait until the debt is paid.
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter limits the inbound messages and bytes of a session, it is only used in readThread.
+type rateLimiter struct {
+	action RateLimitAction
+	msgs   *tokenBucket
+	bytes  *tokenBucket
+	reader io.Reader // 限制字节数时，解码从此读取
+	count  *countingReader
+}
+
+// newRateLimiter returns nil if no limit is set
+func newRateLimiter(opts *Options, r io.Reader) *rateLimiter {
+	if opts.MsgRateLimit <= 0 && opts.ByteRateLimit <= 0 {
+		return nil
+	}
+	l := &rateLimiter{action: opts.RateLimitAction}
+	if opts.MsgRateLimit > 0 {
+		l.msgs = newTokenBucket(opts.MsgRateLimit, opts.MsgRateBurst)
+	}
+	if opts.ByteRateLimit > 0 {
+		l.bytes = newTokenBucket(opts.ByteRateLimit, opts.ByteRateBurst)
+		if _, ok := r.(messageReader); ok {
+			mr := &countingMessageReader{countingReader{Reader: r}}
+			l.reader, l.count = mr, &mr.countingReader
+		} else {
+			l.count = &countingReader{Reader: r}
+			l.reader = l.count
+		}
+	}
+	return l
+}
+
+// limit counts a decoded message of n bytes.
+// It returns the time to wait before reading the next message, and whether the limit is exceeded.
+func (l *rateLimiter) limit(n int, delay bool) (wait time.Duration, exceeded bool) {
+	now := time.Now()
+
+	if delay {
+		if l.msgs != nil {
+			wait = l.msgs.take(1, now)
+		}
+		if l.bytes != nil {
+			if w := l.bytes.take(n, now); w > wait {
+				wait = w
+			}
+		}
+		return wait, wait > 0
+	}
+
+	// 消息数和字节数都满足时才消耗
+	if l.msgs != nil {
+		if l.msgs.refill(now); l.msgs.tokens < 1 {
+			return 0, true
+		}
+	}
+	if l.bytes != nil && !l.bytes.allow(n, now) {
+		return 0, true
+	}
+	if l.msgs != nil {
+		l.msgs.tokens--
+	}
+	return 0, false
+}
+
+// countingMessageReader counts the bytes of the messages read
+type countingMessageReader struct {
+	countingReader
+}
+
+func (r *countingMessageReader) ReadMessage() ([]byte, error) {
+	data, err := r.Reader.(messageReader).ReadMessage()
+	r.n += len(data)
+	return data, err
+}
+
+func (r *countingMessageReader) MessageType() int {
+	if t, ok := r.Reader.(interface{ MessageType() int }); ok {
+		return t.MessageType()
+	}
+	return 0
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
@@ -0,0 +1,114 @@
+package dnet
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		recv    int32
+		limited int32
+		reason  error
+	}{
+		{
+			name:    "drop",
+			options: []Option{WithRateLimit(5, 0, RateLimitDrop)},
+			recv:    5,
+			limited: 15,
+		},
+		{
+			name:    "drop bytes",
+			options: []Option{WithRateLimit(0, 30, RateLimitDrop)},
+			recv:    10, // 每条消息 3 字节
+			limited: 10,
+		},
+		{
+			name:    "close",
+			options: []Option{WithRateLimit(5, 0, RateLimitClose)},
+			recv:    5,
+			limited: 1,
+			reason:  ErrRateLimited,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c2.Close()
+
+			var recv, limited int32
+			closed := make(chan error, 1)
+			options := append(tt.options,
+				WithMessageCallback(func(session Session, message interface{}) {
+					atomic.AddInt32(&recv, 1)
+				}),
+				WithErrorCallback(func(session Session, err error) {
+					if err == ErrRateLimited {
+						atomic.AddInt32(&limited, 1)
+					}
+				}),
+				WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+			session := NewTCPSession(c1, options...)
+
+			data, _ := session.opts.Codec.Encode([]byte{1})
+			for i := 0; i < 20; i++ {
+				if _, err := c2.Write(data); err != nil {
+					break
+				}
+			}
+			_ = c2.Close()
+
+			reason := <-closed
+			if tt.reason != nil && reason != tt.reason {
+				t.Fatalf("close reason %v, want %v", reason, tt.reason)
+			}
+			if n := atomic.LoadInt32(&recv); n != tt.recv {
+				t.Fatalf("recv %d, want %d", n, tt.recv)
+			}
+			if n := atomic.LoadInt32(&limited); n != tt.limited {
+				t.Fatalf("limited %d, want %d", n, tt.limited)
+			}
+		})
+	}
+}
+
+func TestSessionRateLimitDelay(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	const count = 11
+	recv := make(chan struct{}, count)
+	session := NewTCPSession(c1,
+		WithRateLimit(20, 0, RateLimitDelay),
+		WithRateBurst(1, 0),
+		WithMessageCallback(func(session Session, message interface{}) {
+			recv <- struct{}{}
+		}))
+	defer session.Close(nil)
+
+	start := time.Now()
+	go func() {
+		data, _ := session.opts.Codec.Encode([]byte{1})
+		for i := 0; i < count; i++ {
+			if _, err := c2.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < count; i++ {
+		select {
+		case <-recv:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("recv timeout, got %d", i)
+		}
+	}
+	// 第一条消息使用突发令牌，之后每 50ms 一条
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Fatalf("%d messages in %v, not delayed", count, elapsed)
+	}
+}
@@ -0,0 +1,447 @@
+package dnet
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defReconnectBackoff    = 100 * time.Millisecond
+	defReconnectMaxBackoff = 30 * time.Second
+)
+
+// resendMessage is a message sent by ReconnectingSession, seq keeps the order across reconnects
+type resendMessage struct {
+	seq      uint64
+	msg      interface{}
+	data     []byte
+	prio     Priority
+	callback func(err error)
+}
+
+func (m resendMessage) done(err error) {
+	if m.callback != nil {
+		m.callback(err)
+	}
+}
+
+// ReconnectingSession is a client session which redials with jittered exponential backoff when the connection is lost.
+// The messages sent while it is disconnected, and the messages not yet written when the connection is lost,
+// are buffered up to Options.ResendBufferSize, and sent in order after it reconnects.
+// The messages written to a lost connection may still be lost.
+//
+// The callbacks of Options are called with the ReconnectingSession, and CloseCallback is called
+// only when it is closed by Close, or when ReconnectMaxAttempts dials fail in a row.
+type ReconnectingSession struct {
+	id         uint64
+	dial       func() (net.Conn, error)
+	opts       *Options
+	msgHandler InboundHandler // 经过拦截器的 MsgCallback
+	codec      Codec
+	context    interface{}
+	ctxLock    sync.Mutex
+
+	lock     sync.Mutex
+	session  *session // 当前连接的会话，断开时为 nil
+	flushing bool     // 正在发送缓存的消息
+	pending  []resendMessage
+	seq      uint64
+
+	closed      int32
+	closeReason error
+	chClose     chan struct{}
+}
+
+// NewReconnectingSession returns a session which connects with dial in background, and redials when the connection is lost.
+// The default codec is chosen by the first connection, as NewTCPSession, NewWSSession or NewUDPSession does.
+func NewReconnectingSession(dial func() (net.Conn, error), options ...Option) *ReconnectingSession {
+	op := loadOptions(options...)
+	if op.MsgCallback == nil {
+		// need message callback
+		panic(ErrNilMsgCallBack)
+	}
+	if op.ReconnectBackoff <= 0 {
+		op.ReconnectBackoff = defReconnectBackoff
+	}
+	if op.ReconnectMaxBackoff <= 0 {
+		op.ReconnectMaxBackoff = defReconnectMaxBackoff
+	}
+	if op.ReconnectMaxBackoff < op.ReconnectBackoff {
+		op.ReconnectMaxBackoff = op.ReconnectBackoff
+	}
+	if op.ResendBufferSize <= 0 {
+		op.ResendBufferSize = op.SendChannelSize
+		if op.ResendBufferSize <= 0 {
+			op.ResendBufferSize = defSendChannelSize
+		}
+	}
+
+	session := &ReconnectingSession{
+		id:         nextSessionID(),
+		dial:       dial,
+		opts:       op,
+		codec:      op.Codec,
+		msgHandler: chainInbound(op.InboundInterceptors, op.MsgCallback),
+		chClose:    make(chan struct{}),
+	}
+	if op.Hub != nil {
+		op.Hub.Add(session)
+	}
+	go session.run()
+	return session
+}
+
+// defCodec returns the default codec for conn
+func defCodec(conn net.Conn) Codec {
+	switch conn.(type) {
+	case *WSConn:
+		return DefWsCodec{}
+	case *UDPConn:
+		return DefUDPCodec{}
+	default:
+		return DefTCPCodec{}
+	}
+}
+
+// jitter returns a random duration in [d/2, d]
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+func (this *ReconnectingSession) run() {
+	connected := false
+	attempts := 0
+	delay := this.opts.ReconnectBackoff
+	for !this.IsClosed() {
+		attempts++
+		conn, err := this.dial()
+		if err == nil {
+			lost := make(chan error, 1)
+			if this.connect(conn, lost) {
+				if !connected {
+					connected = true
+					if this.opts.ConnectCallback != nil {
+						this.opts.ConnectCallback(this)
+					}
+				} else if this.opts.ReconnectCallback != nil {
+					this.opts.ReconnectCallback(this, attempts)
+				}
+				attempts, delay = 0, this.opts.ReconnectBackoff
+			}
+
+			reason := <-lost
+			this.lock.Lock()
+			this.session = nil
+			this.lock.Unlock()
+			if this.IsClosed() {
+				break
+			}
+			if this.opts.DisconnectCallback != nil {
+				this.opts.DisconnectCallback(this, reason)
+			}
+			// 断开后立即重连
+			continue
+		}
+
+		if this.opts.ErrorCallback != nil {
+			this.opts.ErrorCallback(this, err)
+		}
+		if this.opts.ReconnectMaxAttempts > 0 && attempts >= this.opts.ReconnectMaxAttempts {
+			this.Close(err)
+			break
+		}
+
+		timer := time.NewTimer(jitter(delay))
+		select {
+		case <-timer.C:
+		case <-this.chClose:
+			timer.Stop()
+		}
+		if delay *= 2; delay > this.opts.ReconnectMaxBackoff {
+			delay = this.opts.ReconnectMaxBackoff
+		}
+	}
+
+	this.lock.Lock()
+	pending := this.pending
+	this.pending = nil
+	reason := this.closeReason
+	this.lock.Unlock()
+	for _, m := range pending {
+		m.done(ErrSessionClosed)
+	}
+	if this.opts.Hub != nil {
+		this.opts.Hub.Remove(this)
+	}
+	if this.opts.CloseCallback != nil {
+		this.opts.CloseCallback(this, reason)
+	}
+}
+
+// connect builds the session on conn, and sends the buffered messages.
+// lost receives the reason after the session is closed.
+// It returns false if the connection is lost or the ReconnectingSession is closed before the messages are sent.
+func (this *ReconnectingSession) connect(conn net.Conn, lost chan error) bool {
+	this.lock.Lock()
+	if this.codec == nil {
+		this.codec = defCodec(conn)
+	}
+	opts := *this.opts
+	opts.Codec = this.codec
+	opts.Hub = nil
+	// 拦截器在外层调用
+	opts.InboundInterceptors = nil
+	opts.OutboundInterceptors = nil
+	opts.MsgCallback = func(_ Session, message interface{}) {
+		this.msgHandler(this, message)
+	}
+	if this.opts.ErrorCallback != nil {
+		opts.ErrorCallback = func(_ Session, err error) {
+			this.opts.ErrorCallback(this, err)
+		}
+	}
+	opts.CloseCallback = func(_ Session, reason error) {
+		lost <- reason
+	}
+	s := newSession(conn, &opts)
+	this.session = s
+	this.flushing = true
+	this.lock.Unlock()
+
+	for {
+		this.lock.Lock()
+		if len(this.pending) == 0 || this.IsClosed() {
+			this.flushing = false
+			reason := this.closeReason
+			this.lock.Unlock()
+			if this.IsClosed() {
+				s.Close(reason)
+				return false
+			}
+			return true
+		}
+		m := this.pending[0]
+		this.pending = this.pending[1:]
+		this.lock.Unlock()
+
+		if m.data == nil {
+			msg, _ := unwrapMessage(m.msg)
+			data, err := this.codec.Encode(msg)
+			if err != nil {
+				m.done(err)
+				if this.opts.ErrorCallback != nil {
+					this.opts.ErrorCallback(this, err)
+				}
+				continue
+			}
+			m.data = data
+		}
+		if err := s.send(context.Background(), this.outMessage(m)); err != nil {
+			// 连接已断开
+			this.requeue(m)
+			this.lock.Lock()
+			this.flushing = false
+			this.lock.Unlock()
+			return false
+		}
+	}
+}
+
+// outMessage returns the message sent to the current session, it is buffered again if it is not written
+func (this *ReconnectingSession) outMessage(m resendMessage) outMessage {
+	return outMessage{msg: m.msg, data: m.data, prio: m.prio, callback: func(err error) {
+		if err == nil || err == ErrSendDropped || this.IsClosed() {
+			m.done(err)
+			return
+		}
+		this.requeue(m)
+	}}
+}
+
+// requeue buffers the message again in the order of seq, the oldest message is dropped if the buffer is full.
+func (this *ReconnectingSession) requeue(m resendMessage) {
+	this.lock.Lock()
+	if this.IsClosed() && this.session == nil {
+		this.lock.Unlock()
+		m.done(ErrSessionClosed)
+		return
+	}
+	i := sort.Search(len(this.pending), func(i int) bool { return this.pending[i].seq > m.seq })
+	this.pending = append(this.pending, resendMessage{})
+	copy(this.pending[i+1:], this.pending[i:])
+	this.pending[i] = m
+
+	var dropped []resendMessage
+	if n := len(this.pending) - this.opts.ResendBufferSize; n > 0 {
+		dropped = append(dropped, this.pending[:n]...)
+		this.pending = this.pending[n:]
+	}
+	this.lock.Unlock()
+	for _, d := range dropped {
+		d.done(ErrSendDropped)
+	}
+}
+
+// send passes the message through OutboundInterceptors, and sends or buffers it
+func (this *ReconnectingSession) send(ctx context.Context, o interface{}, prio Priority, callback func(err error)) error {
+	return outbound(this.opts.OutboundInterceptors, this, o, func(_ Session, o interface{}) error {
+		return this.enqueue(ctx, o, prio, callback)
+	})
+}
+
+func (this *ReconnectingSession) enqueue(ctx context.Context, o interface{}, prio Priority, callback func(err error)) error {
+	if o == nil {
+		return ErrSendMsgNil
+	}
+	if this.IsClosed() {
+		return ErrSessionClosed
+	}
+
+	m := resendMessage{msg: o, prio: prio, callback: callback}
+	this.lock.Lock()
+	codec := this.codec
+	this.lock.Unlock()
+	if codec != nil {
+		// 第一次连接前未确定编码器，连接后编码
+		msg, _ := unwrapMessage(o)
+		data, err := codec.Encode(msg)
+		if err != nil {
+			return err
+		}
+		m.data = data
+	}
+
+	this.lock.Lock()
+	this.seq++
+	m.seq = this.seq
+	s := this.session
+	if s == nil || this.flushing {
+		err := this.buffer(m)
+		this.lock.Unlock()
+		return err
+	}
+	this.lock.Unlock()
+
+	err := s.send(ctx, this.outMessage(m))
+	if err == ErrSessionClosed && !this.IsClosed() {
+		// 连接断开，等待重连
+		this.lock.Lock()
+		err = this.buffer(m)
+		this.lock.Unlock()
+	}
+	return err
+}
+
+// buffer buffers a new message in the order of seq, it returns ErrSendChanFull if the buffer is full
+func (this *ReconnectingSession) buffer(m resendMessage) error {
+	if len(this.pending) >= this.opts.ResendBufferSize {
+		return ErrSendChanFull
+	}
+	i := sort.Search(len(this.pending), func(i int) bool { return this.pending[i].seq > m.seq })
+	this.pending = append(this.pending, resendMessage{})
+	copy(this.pending[i+1:], this.pending[i:])
+	this.pending[i] = m
+	return nil
+}
+
+// Send sends the message on the current connection, or buffers it while disconnected.
+func (this *ReconnectingSession) Send(o interface{}) error {
+	return this.send(nil, o, PriorityNormal, nil)
+}
+
+// SendContext is like Send, it waits for the space of the send queue of the current connection until ctx is done.
+func (this *ReconnectingSession) SendContext(ctx context.Context, o interface{}) error {
+	return this.send(ctx, o, PriorityNormal, nil)
+}
+
+// SendCallback is like Send, callback is called after the message is written to a connection,
+// or with the error if it fails to be encoded or the session is closed.
+func (this *ReconnectingSession) SendCallback(o interface{}, callback func(err error)) error {
+	return this.send(nil, o, PriorityNormal, callback)
+}
+
+// SendPriority sends the message in the lane of prio, see session.SendPriority.
+func (this *ReconnectingSession) SendPriority(o interface{}, prio Priority) error {
+	if prio < PriorityHigh || prio > PriorityLow {
+		return ErrInvalidPriority
+	}
+	return this.send(nil, o, prio, nil)
+}
+
+func (this *ReconnectingSession) current() *session {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.session
+}
+
+// IsConnected reports whether the session has a connection
+func (this *ReconnectingSession) IsConnected() bool {
+	s := this.current()
+	return s != nil && !s.IsClosed()
+}
+
+func (this *ReconnectingSession) ID() uint64 {
+	return this.id
+}
+
+// NetConn returns the current connection, or nil while disconnected
+func (this *ReconnectingSession) NetConn() interface{} {
+	if s := this.current(); s != nil {
+		return s.conn
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote network address of the current connection, or nil while disconnected
+func (this *ReconnectingSession) RemoteAddr() net.Addr {
+	if s := this.current(); s != nil {
+		return s.RemoteAddr()
+	}
+	return nil
+}
+
+// LocalAddr returns the local network address of the current connection, or nil while disconnected
+func (this *ReconnectingSession) LocalAddr() net.Addr {
+	if s := this.current(); s != nil {
+		return s.LocalAddr()
+	}
+	return nil
+}
+
+func (this *ReconnectingSession) SetContext(context interface{}) {
+	this.ctxLock.Lock()
+	defer this.ctxLock.Unlock()
+	this.context = context
+}
+
+func (this *ReconnectingSession) Context() interface{} {
+	this.ctxLock.Lock()
+	defer this.ctxLock.Unlock()
+	return this.context
+}
+
+func (this *ReconnectingSession) IsClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+// Close stops redialing, and closes the current connection after its send queue is drained.
+// The buffered messages are dropped with ErrSessionClosed.
+func (this *ReconnectingSession) Close(reason error) {
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		return
+	}
+	this.lock.Lock()
+	this.closeReason = reason
+	s := this.session
+	this.lock.Unlock()
+	close(this.chClose)
+	if s != nil {
+		s.Close(reason)
+	}
+}
@@ -0,0 +1,106 @@
+package dnet
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReconnectingSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	events := make(chan string, 16)
+	recv := make(chan byte, 16)
+	closed := make(chan error, 1)
+	session := NewReconnectingSession(func() (net.Conn, error) {
+		return DialTCP(address, time.Second)
+	},
+		WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond),
+		WithResendBufferSize(4),
+		WithMessageCallback(func(session Session, message interface{}) {
+			recv <- message.([]byte)[0]
+		}),
+		WithConnectCallback(func(session Session) { events <- "connect" }),
+		WithDisconnectCallback(func(session Session, reason error) { events <- "disconnect" }),
+		WithReconnectCallback(func(session Session, attempts int) { events <- "reconnect" }),
+		WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+
+	expect := func(event string) {
+		select {
+		case e := <-events:
+			if e != event {
+				t.Fatalf("event %s, want %s", e, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s timeout", event)
+		}
+	}
+	expectRecv := func(from, to byte) {
+		for i := from; i < to; i++ {
+			select {
+			case b := <-recv:
+				if b != i {
+					t.Fatalf("recv %d, want %d", b, i)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("recv %d timeout", i)
+			}
+		}
+	}
+
+	// 服务器未启动时缓存
+	for i := byte(0); i < 4; i++ {
+		if err := session.Send([]byte{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := session.Send([]byte{4}); err != ErrSendChanFull {
+		t.Fatalf("send to the full buffer %v", err)
+	}
+
+	servers := make(chan Session, 2)
+	acceptor := NewTCPAcceptor(address)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		servers <- NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+	})
+	defer acceptor.Stop()
+
+	expect("connect")
+	expectRecv(0, 4)
+
+	// 断线后缓存，重连后按序发送
+	(<-servers).Close(nil)
+	expect("disconnect")
+	for i := byte(4); i < 8; i++ {
+		if err := session.Send([]byte{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect("reconnect")
+	expectRecv(4, 8)
+	if !session.IsConnected() {
+		t.Fatal("session is not connected")
+	}
+
+	reason := errors.New("done")
+	session.Close(reason)
+	select {
+	case err := <-closed:
+		if err != reason {
+			t.Fatalf("close reason %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+	if err := session.Send([]byte{8}); err != ErrSessionClosed {
+		t.Fatalf("send after close %v", err)
+	}
+}
@@ -0,0 +1,747 @@
+package dnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defResumeTimeout = 30 * time.Second
+	resumeAckCount   = 32                     // 收到多少条消息后立即确认
+	resumeAckDelay   = 100 * time.Millisecond // 确认的最大延迟
+	resumeMaxFrame   = 16 * 1024 * 1024
+	resumeTokenSize  = 16
+)
+
+// the types of the resumption frames
+const (
+	resumeHello   byte = iota + 1 // token, ack
+	resumeWelcome                 // token, ack
+	resumeReject
+	resumeData // seq, ack, payload
+	resumeAck  // ack
+	resumeClose
+	resumePing
+	resumePong
+)
+
+// ResumeToken identifies a resumable session
+type ResumeToken [resumeTokenSize]byte
+
+// resumeFrame is the frame of the resumption layer, it is length-prefixed on the connection
+type resumeFrame struct {
+	typ     byte
+	token   ResumeToken
+	seq     uint64
+	ack     uint64
+	payload []byte
+}
+
+// resumeCodec encodes and decodes the resumeFrame
+type resumeCodec struct{}
+
+// 解码
+func (_ resumeCodec) Decode(reader io.Reader) (interface{}, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(reader, hdr); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr)
+	if length == 0 || length > resumeMaxFrame {
+		return nil, ErrResumeProtocol
+	}
+	buff := make([]byte, length)
+	if _, err := io.ReadFull(reader, buff); err != nil {
+		return nil, err
+	}
+
+	f := &resumeFrame{typ: buff[0]}
+	body := buff[1:]
+	switch f.typ {
+	case resumeHello, resumeWelcome:
+		if len(body) != resumeTokenSize+8 {
+			return nil, ErrResumeProtocol
+		}
+		copy(f.token[:], body)
+		f.ack = binary.BigEndian.Uint64(body[resumeTokenSize:])
+	case resumeData:
+		if len(body) < 16 {
+			return nil, ErrResumeProtocol
+		}
+		f.seq = binary.BigEndian.Uint64(body)
+		f.ack = binary.BigEndian.Uint64(body[8:])
+		f.payload = body[16:]
+	case resumeAck:
+		if len(body) != 8 {
+			return nil, ErrResumeProtocol
+		}
+		f.ack = binary.BigEndian.Uint64(body)
+	case resumeReject, resumeClose, resumePing, resumePong:
+	default:
+		return nil, ErrResumeProtocol
+	}
+	return f, nil
+}
+
+// 编码
+func (_ resumeCodec) Encode(o interface{}) ([]byte, error) {
+	f, ok := o.(*resumeFrame)
+	if !ok {
+		return nil, fmt.Errorf("dnet:resumeCodec encode interface{} is %s, need type *resumeFrame", reflect.TypeOf(o))
+	}
+
+	var body []byte
+	switch f.typ {
+	case resumeHello, resumeWelcome:
+		body = make([]byte, resumeTokenSize+8)
+		copy(body, f.token[:])
+		binary.BigEndian.PutUint64(body[resumeTokenSize:], f.ack)
+	case resumeData:
+		body = make([]byte, 16, 16+len(f.payload))
+		binary.BigEndian.PutUint64(body, f.seq)
+		binary.BigEndian.PutUint64(body[8:], f.ack)
+		body = append(body, f.payload...)
+	case resumeAck:
+		body = make([]byte, 8)
+		binary.BigEndian.PutUint64(body, f.ack)
+	}
+	if len(body)+1 > resumeMaxFrame {
+		return nil, ErrMessageTooLarge
+	}
+
+	buff := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(buff, uint32(len(body)+1))
+	buff[4] = f.typ
+	return append(buff, body...), nil
+}
+
+func (_ resumeCodec) Ping() interface{} { return &resumeFrame{typ: resumePing} }
+
+func (_ resumeCodec) Pong() interface{} { return &resumeFrame{typ: resumePong} }
+
+func (_ resumeCodec) IsPing(msg interface{}) bool {
+	f, ok := msg.(*resumeFrame)
+	return ok && f.typ == resumePing
+}
+
+func (_ resumeCodec) IsPong(msg interface{}) bool {
+	f, ok := msg.(*resumeFrame)
+	return ok && f.typ == resumePong
+}
+
+// writeFrame writes a frame to conn before a session is built on it
+func writeFrame(conn net.Conn, f *resumeFrame) error {
+	data, err := resumeCodec{}.Encode(f)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// readFrame reads a frame of typ, or a reject, from conn within the handshake timeout
+func readFrame(conn net.Conn, typ byte) (*resumeFrame, error) {
+	_ = conn.SetDeadline(time.Now().Add(defHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	o, err := resumeCodec{}.Decode(conn)
+	if err != nil {
+		return nil, err
+	}
+	f := o.(*resumeFrame)
+	if f.typ == resumeReject {
+		return nil, ErrResumeRejected
+	}
+	if f.typ != typ {
+		return nil, ErrResumeProtocol
+	}
+	return f, nil
+}
+
+// resumeMessage is a message waiting for the acknowledgement of the peer
+type resumeMessage struct {
+	seq      uint64
+	payload  []byte
+	callback func(err error)
+}
+
+// ResumableSession is a reliable layer over the session, it survives the connection changes.
+// The outbound messages are numbered and kept until the peer acknowledges them.
+// When a client resumes the session with a new connection, the server attaches the connection
+// to the existing session, and both sides replay only the messages missing on the other side.
+//
+// The callbacks of Options are called with the ResumableSession. DisconnectCallback is called
+// when the connection is lost, ResumeCallback after the session is resumed, and CloseCallback
+// when the session is closed, or not resumed within ResumeTimeout on the server.
+type ResumableSession struct {
+	id         uint64
+	token      ResumeToken
+	opts       *Options
+	msgHandler InboundHandler // 经过拦截器的 MsgCallback
+	server     *ResumeServer  // 服务端的会话
+	context    interface{}
+	ctxLock    sync.Mutex
+
+	lock     sync.Mutex
+	session  *session // 当前连接的会话，断开时为 nil
+	sendSeq  uint64
+	unacked  []resumeMessage
+	space    chan struct{} // 确认消息后关闭，唤醒等待空位的 SendContext
+	recvSeq  uint64
+	acked    uint64 // 已确认的 recvSeq
+	ackTimer *time.Timer
+	expire   *time.Timer
+
+	closed      int32
+	closeReason error
+	chClose     chan struct{}
+}
+
+func newResumableSession(options *Options, server *ResumeServer) (*ResumableSession, error) {
+	if options.MsgCallback == nil {
+		// need message callback
+		panic(ErrNilMsgCallBack)
+	}
+	if options.Codec == nil {
+		options.Codec = DefTCPCodec{}
+	}
+	if options.ResumeTimeout <= 0 {
+		options.ResumeTimeout = defResumeTimeout
+	}
+	if options.ResumeBufferSize <= 0 {
+		options.ResumeBufferSize = options.SendChannelSize
+		if options.ResumeBufferSize <= 0 {
+			options.ResumeBufferSize = defSendChannelSize
+		}
+	}
+
+	session := &ResumableSession{
+		id:         nextSessionID(),
+		opts:       options,
+		msgHandler: chainInbound(options.InboundInterceptors, options.MsgCallback),
+		server:     server,
+		chClose:    make(chan struct{}),
+	}
+	if _, err := rand.Read(session.token[:]); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// NewResumableSession does the handshake on the client connection, and returns a new session resumable by Resume.
+func NewResumableSession(conn net.Conn, options ...Option) (*ResumableSession, error) {
+	session, err := newResumableSession(loadOptions(options...), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, &resumeFrame{typ: resumeHello}); err != nil {
+		return nil, err
+	}
+	f, err := readFrame(conn, resumeWelcome)
+	if err != nil {
+		return nil, err
+	}
+	session.token = f.token
+	if session.opts.Hub != nil {
+		session.opts.Hub.Add(session)
+	}
+	session.attach(conn, f.ack)
+	return session, nil
+}
+
+// Resume resumes the session with a new client connection,
+// the messages missing on either side are replayed.
+// The session is closed with ErrResumeRejected if the server doesn't know it anymore.
+func (this *ResumableSession) Resume(conn net.Conn) error {
+	if this.IsClosed() {
+		return ErrSessionClosed
+	}
+	this.lock.Lock()
+	hello := &resumeFrame{typ: resumeHello, token: this.token, ack: this.recvSeq}
+	this.lock.Unlock()
+
+	if err := writeFrame(conn, hello); err != nil {
+		return err
+	}
+	f, err := readFrame(conn, resumeWelcome)
+	if err != nil {
+		if err == ErrResumeRejected {
+			_ = conn.Close()
+			this.Close(err)
+		}
+		return err
+	}
+	this.attach(conn, f.ack)
+	if this.opts.ResumeCallback != nil {
+		this.opts.ResumeCallback(this)
+	}
+	return nil
+}
+
+// Token returns the resume token
+func (this *ResumableSession) Token() ResumeToken {
+	return this.token
+}
+
+// attach builds the session on conn, and replays the messages not acknowledged by ack
+func (this *ResumableSession) attach(conn net.Conn, ack uint64) {
+	opts := *this.opts
+	opts.Codec = resumeCodec{}
+	opts.Hub = nil
+	// 拦截器在外层调用
+	opts.InboundInterceptors = nil
+	opts.OutboundInterceptors = nil
+	opts.BlockSend = false
+	opts.OverflowPolicy = OverflowReject
+	// 容纳所有未确认的消息和确认
+	opts.SendChannelSize = 2*this.opts.ResumeBufferSize + resumeAckCount
+	opts.ErrorCallback = nil
+	if this.opts.ErrorCallback != nil {
+		opts.ErrorCallback = func(_ Session, err error) {
+			this.opts.ErrorCallback(this, err)
+		}
+	}
+
+	this.lock.Lock()
+	if this.IsClosed() {
+		this.lock.Unlock()
+		_ = conn.Close()
+		return
+	}
+	opts.MsgCallback = func(s Session, message interface{}) {
+		this.receive(s.(*session), message.(*resumeFrame))
+	}
+	opts.CloseCallback = func(s Session, reason error) {
+		this.detach(s.(*session), reason)
+	}
+	old := this.session
+	if this.expire != nil {
+		this.expire.Stop()
+		this.expire = nil
+	}
+	done := this.ackLocked(ack)
+	s := newSession(conn, &opts)
+	this.session = s
+	for _, m := range this.unacked {
+		this.push(s, &resumeFrame{typ: resumeData, seq: m.seq, ack: this.recvSeq, payload: m.payload})
+	}
+	this.acked = this.recvSeq
+	this.lock.Unlock()
+
+	if old != nil {
+		// 旧连接可能半开
+		old.Close(ErrSessionClosed)
+	}
+	for _, m := range done {
+		m.callback(nil)
+	}
+}
+
+// detach is called after the session s is closed
+func (this *ResumableSession) detach(s *session, reason error) {
+	this.lock.Lock()
+	if this.session != s {
+		this.lock.Unlock()
+		return
+	}
+	this.session = nil
+	if this.IsClosed() {
+		this.lock.Unlock()
+		this.finish()
+		return
+	}
+	if this.server != nil {
+		this.expire = time.AfterFunc(this.opts.ResumeTimeout, func() {
+			this.Close(ErrResumeTimeout)
+		})
+	}
+	this.lock.Unlock()
+
+	if this.opts.DisconnectCallback != nil {
+		this.opts.DisconnectCallback(this, reason)
+	}
+}
+
+// push puts the frame in the send queue of s, the connection is closed if it fails, and the frame is replayed after resumed.
+func (this *ResumableSession) push(s *session, f *resumeFrame) {
+	if err := s.send(nil, outMessage{msg: f, prio: PriorityNormal}); err != nil && err != ErrSessionClosed {
+		s.Close(err)
+	}
+}
+
+// ackLocked drops the messages acknowledged by ack, and returns the ones with callback
+func (this *ResumableSession) ackLocked(ack uint64) []resumeMessage {
+	n := 0
+	for n < len(this.unacked) && this.unacked[n].seq <= ack {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	var done []resumeMessage
+	for _, m := range this.unacked[:n] {
+		if m.callback != nil {
+			done = append(done, m)
+		}
+	}
+	copy(this.unacked, this.unacked[n:])
+	for i := len(this.unacked) - n; i < len(this.unacked); i++ {
+		this.unacked[i] = resumeMessage{}
+	}
+	this.unacked = this.unacked[:len(this.unacked)-n]
+	if this.space != nil {
+		close(this.space)
+		this.space = nil
+	}
+	return done
+}
+
+// receive handles a frame received on the session s
+func (this *ResumableSession) receive(s *session, f *resumeFrame) {
+	switch f.typ {
+	case resumeClose:
+		this.Close(io.EOF)
+		return
+	case resumeData, resumeAck:
+	default:
+		s.Close(ErrResumeProtocol)
+		return
+	}
+
+	this.lock.Lock()
+	if this.session != s {
+		this.lock.Unlock()
+		return
+	}
+	if f.ack > this.sendSeq {
+		this.lock.Unlock()
+		s.Close(ErrResumeProtocol)
+		return
+	}
+	done := this.ackLocked(f.ack)
+	deliver := false
+	if f.typ == resumeData {
+		if f.seq > this.recvSeq+1 {
+			this.lock.Unlock()
+			s.Close(ErrResumeProtocol)
+			return
+		}
+		// 重放的消息可能已收到
+		if f.seq == this.recvSeq+1 {
+			this.recvSeq = f.seq
+			deliver = true
+			this.scheduleAck(s)
+		}
+	}
+	this.lock.Unlock()
+
+	for _, m := range done {
+		m.callback(nil)
+	}
+	if deliver {
+		msg, err := this.opts.Codec.Decode(bytes.NewReader(f.payload))
+		if err != nil {
+			if this.opts.ErrorCallback != nil {
+				this.opts.ErrorCallback(this, err)
+			}
+			return
+		}
+		this.msgHandler(this, msg)
+	}
+}
+
+// scheduleAck sends the acknowledgement after resumeAckCount messages, or after resumeAckDelay
+func (this *ResumableSession) scheduleAck(s *session) {
+	if this.recvSeq-this.acked >= resumeAckCount {
+		this.sendAck(s)
+		return
+	}
+	if this.ackTimer == nil {
+		this.ackTimer = time.AfterFunc(resumeAckDelay, func() {
+			this.lock.Lock()
+			this.ackTimer = nil
+			if this.session == s && this.recvSeq != this.acked {
+				this.sendAck(s)
+			}
+			this.lock.Unlock()
+		})
+	}
+}
+
+func (this *ResumableSession) sendAck(s *session) {
+	this.acked = this.recvSeq
+	this.push(s, &resumeFrame{typ: resumeAck, ack: this.recvSeq})
+}
+
+// send passes the message through OutboundInterceptors, and numbers and sends it.
+// It waits for the space of the resume buffer until ctx is done if ctx is not nil.
+func (this *ResumableSession) send(ctx context.Context, o interface{}, callback func(err error)) error {
+	return outbound(this.opts.OutboundInterceptors, this, o, func(_ Session, o interface{}) error {
+		return this.enqueue(ctx, o, callback)
+	})
+}
+
+func (this *ResumableSession) enqueue(ctx context.Context, o interface{}, callback func(err error)) error {
+	if o == nil {
+		return ErrSendMsgNil
+	}
+	if this.IsClosed() {
+		return ErrSessionClosed
+	}
+	payload, err := this.opts.Codec.Encode(o)
+	if err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for {
+		if this.IsClosed() {
+			return ErrSessionClosed
+		}
+		if len(this.unacked) < this.opts.ResumeBufferSize {
+			break
+		}
+		if ctx == nil {
+			return ErrSendChanFull
+		}
+		if this.space == nil {
+			this.space = make(chan struct{})
+		}
+		space := this.space
+		this.lock.Unlock()
+		select {
+		case <-space:
+			this.lock.Lock()
+		case <-this.chClose:
+			this.lock.Lock()
+		case <-ctx.Done():
+			this.lock.Lock()
+			return ctx.Err()
+		}
+	}
+	this.sendSeq++
+	this.unacked = append(this.unacked, resumeMessage{seq: this.sendSeq, payload: payload, callback: callback})
+	if s := this.session; s != nil {
+		this.acked = this.recvSeq
+		this.push(s, &resumeFrame{typ: resumeData, seq: this.sendSeq, ack: this.recvSeq, payload: payload})
+	}
+	return nil
+}
+
+// Send numbers the message and sends it, or keeps it until resumed while disconnected.
+// It returns ErrSendChanFull if ResumeBufferSize messages are not acknowledged.
+func (this *ResumableSession) Send(o interface{}) error {
+	return this.send(nil, o, nil)
+}
+
+// SendContext is like Send, but it waits for the space of the resume buffer until ctx is done.
+func (this *ResumableSession) SendContext(ctx context.Context, o interface{}) error {
+	return this.send(ctx, o, nil)
+}
+
+// SendCallback is like Send, callback is called with nil after the peer acknowledges the message,
+// or with ErrSessionClosed if the session is closed before that.
+func (this *ResumableSession) SendCallback(o interface{}, callback func(err error)) error {
+	return this.send(nil, o, callback)
+}
+
+func (this *ResumableSession) current() *session {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.session
+}
+
+// IsConnected reports whether the session has a connection
+func (this *ResumableSession) IsConnected() bool {
+	return this.current() != nil
+}
+
+func (this *ResumableSession) ID() uint64 {
+	return this.id
+}
+
+// NetConn returns the current connection, or nil while disconnected
+func (this *ResumableSession) NetConn() interface{} {
+	if s := this.current(); s != nil {
+		return s.conn
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote network address of the current connection, or nil while disconnected
+func (this *ResumableSession) RemoteAddr() net.Addr {
+	if s := this.current(); s != nil {
+		return s.RemoteAddr()
+	}
+	return nil
+}
+
+// LocalAddr returns the local network address of the current connection, or nil while disconnected
+func (this *ResumableSession) LocalAddr() net.Addr {
+	if s := this.current(); s != nil {
+		return s.LocalAddr()
+	}
+	return nil
+}
+
+func (this *ResumableSession) SetContext(context interface{}) {
+	this.ctxLock.Lock()
+	defer this.ctxLock.Unlock()
+	this.context = context
+}
+
+func (this *ResumableSession) Context() interface{} {
+	this.ctxLock.Lock()
+	defer this.ctxLock.Unlock()
+	return this.context
+}
+
+func (this *ResumableSession) IsClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+// Close tells the peer to close the session, and closes the connection after its send queue is drained.
+// The messages not acknowledged are dropped with ErrSessionClosed.
+func (this *ResumableSession) Close(reason error) {
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		return
+	}
+	close(this.chClose)
+	this.lock.Lock()
+	this.closeReason = reason
+	if this.expire != nil {
+		this.expire.Stop()
+	}
+	s := this.session
+	this.lock.Unlock()
+
+	if s == nil {
+		this.finish()
+		return
+	}
+	if reason != io.EOF {
+		// 通知对端关闭
+		this.push(s, &resumeFrame{typ: resumeClose})
+	}
+	s.Close(reason)
+}
+
+// finish drops the messages not acknowledged, and calls CloseCallback
+func (this *ResumableSession) finish() {
+	this.lock.Lock()
+	unacked := this.unacked
+	this.unacked = nil
+	reason := this.closeReason
+	this.lock.Unlock()
+
+	for _, m := range unacked {
+		if m.callback != nil {
+			m.callback(ErrSessionClosed)
+		}
+	}
+	if this.server != nil {
+		this.server.remove(this)
+	}
+	if this.opts.Hub != nil {
+		this.opts.Hub.Remove(this)
+	}
+	if this.opts.CloseCallback != nil {
+		this.opts.CloseCallback(this, reason)
+	}
+}
+
+// ResumeServer keeps the resumable sessions of the server, they can be resumed by the token
+// within ResumeTimeout after the connection is lost.
+type ResumeServer struct {
+	options  []Option
+	lock     sync.Mutex
+	sessions map[ResumeToken]*ResumableSession
+}
+
+// NewResumeServer returns a server which builds the resumable sessions with options
+func NewResumeServer(options ...Option) *ResumeServer {
+	return &ResumeServer{options: options, sessions: map[ResumeToken]*ResumableSession{}}
+}
+
+// Accept does the handshake on conn, call it in OnConnection.
+// It returns a new session, or the existing session resumed by conn with resumed true.
+// conn is closed if the handshake fails, or with ErrResumeRejected if the session to resume is unknown.
+func (s *ResumeServer) Accept(conn net.Conn) (session *ResumableSession, resumed bool, err error) {
+	hello, err := readFrame(conn, resumeHello)
+	if err != nil {
+		_ = conn.Close()
+		return nil, false, err
+	}
+
+	if hello.token != (ResumeToken{}) {
+		s.lock.Lock()
+		session = s.sessions[hello.token]
+		s.lock.Unlock()
+		if session == nil || session.IsClosed() || hello.ack > session.sendSeqValue() {
+			_ = writeFrame(conn, &resumeFrame{typ: resumeReject})
+			_ = conn.Close()
+			return nil, false, ErrResumeRejected
+		}
+
+		session.lock.Lock()
+		welcome := &resumeFrame{typ: resumeWelcome, token: session.token, ack: session.recvSeq}
+		session.lock.Unlock()
+		if err := writeFrame(conn, welcome); err != nil {
+			_ = conn.Close()
+			return nil, false, err
+		}
+		session.attach(conn, hello.ack)
+		if session.opts.ResumeCallback != nil {
+			session.opts.ResumeCallback(session)
+		}
+		return session, true, nil
+	}
+
+	session, err = newResumableSession(loadOptions(s.options...), s)
+	if err != nil {
+		_ = conn.Close()
+		return nil, false, err
+	}
+	if err := writeFrame(conn, &resumeFrame{typ: resumeWelcome, token: session.token}); err != nil {
+		_ = conn.Close()
+		return nil, false, err
+	}
+	s.lock.Lock()
+	s.sessions[session.token] = session
+	s.lock.Unlock()
+	if session.opts.Hub != nil {
+		session.opts.Hub.Add(session)
+	}
+	session.attach(conn, 0)
+	return session, false, nil
+}
+
+// Len returns the number of the sessions, including the disconnected ones
+func (s *ResumeServer) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.sessions)
+}
+
+func (s *ResumeServer) remove(session *ResumableSession) {
+	s.lock.Lock()
+	if s.sessions[session.token] == session {
+		delete(s.sessions, session.token)
+	}
+	s.lock.Unlock()
+}
+
+func (this *ResumableSession) sendSeqValue() uint64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.sendSeq
+}
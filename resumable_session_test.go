@@ -0,0 +1,211 @@
+package dnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResumableSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	type accepted struct {
+		id      uint64
+		resumed bool
+	}
+	accepts := make(chan accepted, 4)
+	serverClosed := make(chan error, 4)
+	server := NewResumeServer(
+		WithResume(500*time.Millisecond, 256),
+		WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}),
+		WithCloseCallback(func(session Session, reason error) { serverClosed <- reason }))
+	acceptor := NewTCPAcceptor(address)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		if session, resumed, err := server.Accept(conn); err == nil {
+			accepts <- accepted{session.ID(), resumed}
+		}
+	})
+	defer acceptor.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	dial := func() net.Conn {
+		conn, err := DialTCP(address, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	expectAccept := func(resumed bool) accepted {
+		select {
+		case a := <-accepts:
+			if a.resumed != resumed {
+				t.Fatalf("resumed %v, want %v", a.resumed, resumed)
+			}
+			return a
+		case <-time.After(5 * time.Second):
+			t.Fatal("accept timeout")
+		}
+		return accepted{}
+	}
+
+	recv := make(chan int, 256)
+	disconnected := make(chan error, 4)
+	conn := dial()
+	session, err := NewResumableSession(conn,
+		WithResume(0, 256),
+		WithMessageCallback(func(session Session, message interface{}) {
+			b := message.([]byte)
+			recv <- int(b[0])<<8 | int(b[1])
+		}),
+		WithDisconnectCallback(func(session Session, reason error) { disconnected <- reason }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := expectAccept(false)
+
+	// 发送中途断开连接
+	for i := 0; i < 100; i++ {
+		if err := session.Send([]byte{byte(i >> 8), byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnect timeout")
+	}
+	if session.IsConnected() {
+		t.Fatal("session is connected after the connection is lost")
+	}
+
+	// 断开时缓存
+	for i := 100; i < 200; i++ {
+		if err := session.Send([]byte{byte(i >> 8), byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := session.Resume(dial()); err != nil {
+		t.Fatal(err)
+	}
+	if a := expectAccept(true); a.id != first.id {
+		t.Fatalf("resumed session %d, want %d", a.id, first.id)
+	}
+
+	// 每条消息按序送达一次
+	for i := 0; i < 200; i++ {
+		select {
+		case n := <-recv:
+			if n != i {
+				t.Fatalf("recv %d, want %d", n, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("recv %d timeout", i)
+		}
+	}
+	select {
+	case n := <-recv:
+		t.Fatalf("recv %d again", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// 关闭通知对端
+	session.Close(nil)
+	select {
+	case reason := <-serverClosed:
+		if reason != io.EOF {
+			t.Fatalf("server close reason %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server close timeout")
+	}
+
+	// 超时未恢复的会话被拒绝
+	conn = dial()
+	session, err = NewResumableSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAccept(false)
+	conn.Close()
+	select {
+	case reason := <-serverClosed:
+		if reason != ErrResumeTimeout {
+			t.Fatalf("server close reason %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("resume timeout")
+	}
+	if err := session.Resume(dial()); err != ErrResumeRejected {
+		t.Fatalf("resume the expired session %v", err)
+	}
+	if !session.IsClosed() || server.Len() != 0 {
+		t.Fatal("the rejected session is not closed")
+	}
+}
+
+func TestResumableSessionSendContext(t *testing.T) {
+	server := NewResumeServer(WithMessageCallback(func(session Session, message interface{}) {}))
+	dial := func() net.Conn {
+		c1, c2 := net.Pipe()
+		go func() {
+			_, _, _ = server.Accept(c2)
+		}()
+		return c1
+	}
+
+	disconnected := make(chan error, 1)
+	conn := dial()
+	session, err := NewResumableSession(conn,
+		WithResume(0, 2),
+		WithMessageCallback(func(session Session, message interface{}) {}),
+		WithDisconnectCallback(func(session Session, reason error) { disconnected <- reason }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close(nil)
+	conn.Close()
+	<-disconnected
+
+	// 断开时缓存满，等到 ctx 结束
+	for i := 0; i < 2; i++ {
+		if err := session.Send([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := session.Send([]byte{2}); err != ErrSendChanFull {
+		t.Fatalf("send %v, want %v", err, ErrSendChanFull)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := session.SendContext(ctx, []byte{2}); err != context.DeadlineExceeded {
+		t.Fatalf("SendContext %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 恢复后对端确认，等待的消息发出
+	result := make(chan error, 1)
+	go func() {
+		result <- session.SendContext(context.Background(), []byte{2})
+	}()
+	if err := session.Resume(dial()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendContext is not woken by the acknowledgement")
+	}
+}
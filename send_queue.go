@@ -0,0 +1,179 @@
+package dnet
+
+import "sync"
+
+// OverflowPolicy decides what Send does when the send queue is full.
+type OverflowPolicy int32
+
+const (
+	// OverflowReject returns ErrSendChanFull, or waits for the space if BlockSend is true. It is the default.
+	OverflowReject OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued message of the lowest priority to make room for the new one.
+	OverflowDropOldest
+	// OverflowCoalesce replaces the queued message with the same Options.CoalesceKey and priority by the new one
+	// on every Send, not only when the queue is full, so only the latest one is queued.
+	// A message without a queued one of its key is queued, or rejected like OverflowReject if the queue is full.
+	OverflowCoalesce
+	// OverflowClose closes the slow consumer with ErrSlowConsumer.
+	OverflowClose
+)
+
+// Priority is the lane of a message in the send queue, the smaller the higher.
+type Priority int
+
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+	priorityCount = iota
+)
+
+// outMessage is an item of the send queue
+type outMessage struct {
+	msg      interface{} // 待编码的消息
+	data     []byte      // 已编码的数据，如 Hub.Broadcast
+	key      interface{} // OverflowCoalesce 使用
+	prio     Priority
+	callback func(err error) // 写入连接后回调
+}
+
+func (m outMessage) done(err error) {
+	if m.callback != nil {
+		m.callback(err)
+	}
+}
+
+// sendQueue is a bounded queue of outMessage with a FIFO lane for each Priority.
+// Unlike a channel, the queued messages can be dropped or replaced.
+type sendQueue struct {
+	lock     sync.Mutex
+	lanes    [priorityCount][]outMessage
+	skipped  [priorityCount]int // 有消息等待时，更高优先级连续出队的次数
+	share    int
+	size     int
+	capacity int
+	space    chan struct{} // 出队时关闭，唤醒等待空位的发送者
+	closed   bool
+}
+
+func newSendQueue(capacity, share int) *sendQueue {
+	return &sendQueue{capacity: capacity, share: share}
+}
+
+// full reports whether the queue is full, lock must be held.
+func (q *sendQueue) full() bool {
+	return q.size >= q.capacity
+}
+
+// push appends m to its lane, lock must be held and the queue must not be full.
+func (q *sendQueue) push(m outMessage) {
+	q.lanes[m.prio] = append(q.lanes[m.prio], m)
+	q.size++
+}
+
+// shift removes the next message to send, lock must be held.
+// Higher lanes go first, but a waiting lower lane sends one after share messages of the higher lanes.
+func (q *sendQueue) shift() (m outMessage, ok bool) {
+	if q.size == 0 {
+		return
+	}
+
+	next := -1
+	for i := priorityCount - 1; i > 0; i-- {
+		if len(q.lanes[i]) > 0 && q.skipped[i] >= q.share {
+			next = i
+			break
+		}
+	}
+	if next < 0 {
+		for i := 0; i < priorityCount; i++ {
+			if len(q.lanes[i]) > 0 {
+				next = i
+				break
+			}
+		}
+	}
+
+	q.skipped[next] = 0
+	for i := next + 1; i < priorityCount; i++ {
+		if len(q.lanes[i]) > 0 {
+			q.skipped[i]++
+		}
+	}
+	return q.take(next), true
+}
+
+// dropOldest removes the oldest message of the lowest non-empty lane, lock must be held.
+func (q *sendQueue) dropOldest() (m outMessage, ok bool) {
+	for i := priorityCount - 1; i >= 0; i-- {
+		if len(q.lanes[i]) > 0 {
+			return q.take(i), true
+		}
+	}
+	return
+}
+
+// take removes the first message of the lane, lock must be held.
+func (q *sendQueue) take(lane int) outMessage {
+	l := q.lanes[lane]
+	m := l[0]
+	l[0] = outMessage{}
+	if len(l) == 1 {
+		q.lanes[lane] = l[:0]
+	} else {
+		q.lanes[lane] = l[1:]
+	}
+	q.size--
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+	return m
+}
+
+// replace replaces the message with the same key in the lane of m, it returns the replaced one.
+// lock must be held.
+func (q *sendQueue) replace(m outMessage) (old outMessage, ok bool) {
+	if m.key == nil {
+		return
+	}
+	l := q.lanes[m.prio]
+	for i := range l {
+		if l[i].key == m.key {
+			old, ok = l[i], true
+			l[i] = m
+			return
+		}
+	}
+	return
+}
+
+// wait returns a channel which is closed when a message is removed, lock must be held.
+func (q *sendQueue) wait() <-chan struct{} {
+	if q.space == nil {
+		q.space = make(chan struct{})
+	}
+	return q.space
+}
+
+// pop removes the next message to send
+func (q *sendQueue) pop() (outMessage, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.shift()
+}
+
+// close marks the queue closed and returns the messages left
+func (q *sendQueue) close() []outMessage {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	left := make([]outMessage, 0, q.size)
+	for {
+		m, ok := q.shift()
+		if !ok {
+			return left
+		}
+		left = append(left, m)
+	}
+}
@@ -0,0 +1,165 @@
+package dnet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		option Option
+		sends  [][]byte
+		errs   []error
+		recv   [][]byte
+	}{
+		{
+			name:   "drop oldest",
+			option: WithOverflowPolicy(OverflowDropOldest),
+			sends:  [][]byte{{2}, {3}, {4}},
+			errs:   []error{ErrSendDropped, nil, nil},
+			recv:   [][]byte{{1}, {3}, {4}},
+		},
+		{
+			name: "coalesce",
+			option: WithCoalesce(func(o interface{}) interface{} {
+				return o.([]byte)[0]
+			}),
+			sends: [][]byte{{2, 0}, {3, 0}, {2, 1}},
+			errs:  []error{ErrSendDropped, nil, nil},
+			recv:  [][]byte{{1}, {2, 1}, {3, 0}},
+		},
+		{
+			// 队列未满时也替换
+			name: "coalesce before full",
+			option: WithCoalesce(func(o interface{}) interface{} {
+				return o.([]byte)[0]
+			}),
+			sends: [][]byte{{2, 0}, {2, 1}, {3, 0}},
+			errs:  []error{ErrSendDropped, nil, nil},
+			recv:  [][]byte{{1}, {2, 1}, {3, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c2.Close()
+
+			session := NewTCPSession(c1,
+				WithSendChannelSize(2),
+				WithMessageCallback(func(session Session, message interface{}) {}),
+				tt.option)
+			defer session.Close(nil)
+
+			// 第一条消息阻塞在写入中
+			if err := session.Send([]byte{1}); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			errs := make([]chan error, len(tt.sends))
+			for i, msg := range tt.sends {
+				errs[i] = make(chan error, 1)
+				ch := errs[i]
+				if err := session.SendCallback(msg, func(err error) { ch <- err }); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, want := range tt.recv {
+				msg, err := session.opts.Codec.Decode(c2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(msg.([]byte)) != string(want) {
+					t.Fatalf("recv %v, want %v", msg, want)
+				}
+			}
+			for i, want := range tt.errs {
+				select {
+				case err := <-errs[i]:
+					if err != want {
+						t.Fatalf("message %d: %v, want %v", i, err, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("message %d: callback timeout", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionOverflowClose(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	closed := make(chan error, 1)
+	session := NewTCPSession(c1,
+		WithSendChannelSize(1),
+		WithOverflowPolicy(OverflowClose),
+		WithMessageCallback(func(session Session, message interface{}) {}),
+		WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+
+	for i := 0; i < 2; i++ {
+		if err := session.Send([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := session.Send([]byte{2}); err != ErrSlowConsumer {
+		t.Fatalf("send %v, want %v", err, ErrSlowConsumer)
+	}
+
+	select {
+	case reason := <-closed:
+		if reason != ErrSlowConsumer {
+			t.Fatalf("close reason %v, want %v", reason, ErrSlowConsumer)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+}
+
+func TestSessionSendPriority(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	session := NewTCPSession(c1,
+		WithPriorityShare(2),
+		WithMessageCallback(func(session Session, message interface{}) {}))
+	defer session.Close(nil)
+
+	// 第一条消息阻塞在写入中
+	if err := session.Send([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for i := byte(1); i <= 4; i++ {
+		if err := session.SendPriority([]byte{10 + i}, PriorityLow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := byte(1); i <= 4; i++ {
+		if err := session.SendPriority([]byte{20 + i}, PriorityHigh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := session.SendPriority([]byte{0}, Priority(-1)); err != ErrInvalidPriority {
+		t.Fatalf("send %v, want %v", err, ErrInvalidPriority)
+	}
+
+	// 低优先级每 2 条高优先级后发送一条
+	want := []byte{0, 21, 22, 11, 23, 24, 12, 13, 14}
+	for _, w := range want {
+		msg, err := session.opts.Codec.Decode(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.([]byte)[0] != w {
+			t.Fatalf("recv %v, want %d", msg, w)
+		}
+	}
+}
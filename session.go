@@ -1,44 +1,83 @@
 package dnet
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-const defSendChannelSize = 1024
+const (
+	defSendChannelSize = 1024
+	defSendBatchSize   = 64
+	defSendBatchBytes  = 64 * 1024
+	defPriorityShare   = 8
+)
 
 type session struct {
-	opts *Options
+	id         uint64
+	lastRecv   int64 // 最近收到消息的时间，心跳使用
+	opts       *Options
+	msgHandler InboundHandler // 经过拦截器的 MsgCallback
 
 	conn net.Conn
 
 	context interface{} // 用户数据
 	ctxLock sync.Mutex
 
-	sendOnce      sync.Once
-	sendNotifyCh  chan struct{}    // 发送消息通知
-	sendMessageCh chan interface{} // 发送队列
+	sendOnce     sync.Once
+	sendNotifyCh chan struct{} // 发送消息通知
+	sendQueue    *sendQueue    // 发送队列
+
+	slot           *connSlot // 接收此连接的 acceptor 的计数
+	heartbeatTimer *time.Timer
 
-	waitGroup sync.WaitGroup
-	closed    int32
-	chClose   chan struct{}
+	waitGroup   sync.WaitGroup
+	closed      int32
+	closeReason error
+	lock        sync.Mutex // 保护 closeReason 和 heartbeatTimer
+	chClose     chan struct{}
 }
 
 func newSession(conn net.Conn, options *Options) *session {
 	if options.SendChannelSize <= 0 {
 		options.SendChannelSize = defSendChannelSize
 	}
+	if options.SendBatchSize <= 0 {
+		options.SendBatchSize = defSendBatchSize
+	}
+	if options.SendBatchBytes <= 0 {
+		options.SendBatchBytes = defSendBatchBytes
+	}
+	if options.PriorityShare <= 0 {
+		options.PriorityShare = defPriorityShare
+	}
 
+	slot, conn := slotOf(conn)
 	session := &session{
+		id:           nextSessionID(),
 		conn:         conn,
+		slot:         slot,
 		opts:         options,
 		sendNotifyCh: make(chan struct{}, 1),
+		sendQueue:    newSendQueue(options.SendChannelSize, options.PriorityShare),
 		chClose:      make(chan struct{}),
 	}
 
+	if slot != nil {
+		slot.add(session)
+	}
+	if options.Hub != nil {
+		options.Hub.Add(session)
+	}
+	session.startHeartbeat()
+
 	if options.MsgCallback != nil {
+		session.msgHandler = chainInbound(options.InboundInterceptors, options.MsgCallback)
 		session.waitGroup.Add(1)
 		go session.readThread()
 	}
@@ -46,6 +85,10 @@ func newSession(conn net.Conn, options *Options) *session {
 	return session
 }
 
+func (this *session) ID() uint64 {
+	return this.id
+}
+
 func (this *session) SetContext(context interface{}) {
 	this.ctxLock.Lock()
 	this.context = context
@@ -75,15 +118,35 @@ func (this *session) LocalAddr() net.Addr {
 	return this.conn.LocalAddr()
 }
 
-//对端地址
+// 对端地址
 func (this *session) RemoteAddr() net.Addr {
 	return this.conn.RemoteAddr()
 }
 
+// tlsConn is implemented by the TLS connections, such as *tls.Conn
+type tlsConn interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// PeerCertificates returns the certificate chain presented by the peer of a TLS connection,
+// it is nil if the connection is not TLS or the peer presents no certificate.
+func (this *session) PeerCertificates() []*x509.Certificate {
+	if c, ok := this.conn.(tlsConn); ok {
+		return c.ConnectionState().PeerCertificates
+	}
+	return nil
+}
+
 // 接收线程
 func (this *session) readThread() {
 	defer this.waitGroup.Done()
 
+	var reader io.Reader = this.conn
+	limiter := newRateLimiter(this.opts, this.conn)
+	if limiter != nil && limiter.reader != nil {
+		reader = limiter.reader
+	}
+
 	for {
 		if this.opts.ReadTimeout > 0 {
 			if err := this.conn.SetReadDeadline(time.Now().Add(this.opts.ReadTimeout)); err != nil {
@@ -93,7 +156,12 @@ func (this *session) readThread() {
 			}
 		}
 
-		if msg, err := this.opts.Codec.Decode(this.conn); this.IsClosed() {
+		if this.IsClosed() {
+			// Close 设置的读超时可能被覆盖
+			break
+		}
+
+		if msg, err := this.opts.Codec.Decode(reader); this.IsClosed() {
 			break
 
 		} else {
@@ -111,7 +179,15 @@ func (this *session) readThread() {
 				break
 
 			} else if msg != nil {
-				this.opts.MsgCallback(this, msg)
+				if this.opts.HeartbeatInterval > 0 {
+					this.touch()
+				}
+				if limiter != nil && !this.limit(limiter) {
+					continue
+				}
+				if !this.handleHeartbeat(msg) {
+					this.msgHandler(this, msg)
+				}
 			}
 
 		}
@@ -121,113 +197,349 @@ func (this *session) readThread() {
 
 // 发送线程
 // 关闭连接时，发送完后再关闭
+// 每次取出队列中已有的消息，编码后合并为一次 writev 发送
 func (this *session) writeThread() {
 	defer this.waitGroup.Done()
 
+	batch := make(net.Buffers, 0, this.opts.SendBatchSize)
+	types := make([]int, 0, this.opts.SendBatchSize)
+	writer, _ := this.conn.(messageWriter)
+	var callbacks []outMessage
 	for {
-		select {
-		case msg := <-this.sendMessageCh:
-			if data, err := this.opts.Codec.Encode(msg); err != nil {
-				if !this.IsClosed() {
-					if this.opts.ErrorCallback != nil {
-						this.opts.ErrorCallback(this, err)
-					}
-					this.Close(err)
-				}
-				return
-			} else {
-				if data != nil && len(data) != 0 {
-					// 发送的消息
-					if this.opts.WriteTimeout > 0 {
-						if err := this.conn.SetWriteDeadline(time.Now().Add(this.opts.ReadTimeout)); err != nil {
-							if this.opts.ErrorCallback != nil {
-								this.opts.ErrorCallback(this, err)
-							}
-						}
-					}
+		batch, types, callbacks = batch[:0], types[:0], callbacks[:0]
+		size := 0
 
-					idx, length := 0, len(data)
-					for idx < length {
-						if n, err := this.conn.Write(data[idx:length]); err != nil {
-							if !this.IsClosed() {
-								if ne, ok := err.(net.Error); ok {
-									if ne.Timeout() {
-										err = ErrSendTimeout
-									}
-								}
-								if this.opts.ErrorCallback != nil {
-									this.opts.ErrorCallback(this, err)
-								}
-								this.Close(err)
-							}
-							return
-						} else {
-							idx += n
+		for len(batch) < this.opts.SendBatchSize && size < this.opts.SendBatchBytes {
+			m, ok := this.sendQueue.pop()
+			if !ok {
+				break
+			}
+			msg, _ := unwrapMessage(m.msg)
+			typ := messageType(m.msg, writer)
+			data := m.data
+			if data == nil {
+				var err error
+				if data, err = this.opts.Codec.Encode(msg); err != nil {
+					m.done(err)
+					for _, c := range callbacks {
+						c.done(ErrSessionClosed)
+					}
+					if !this.IsClosed() {
+						if this.opts.ErrorCallback != nil {
+							this.opts.ErrorCallback(this, err)
 						}
+						this.Close(err)
 					}
+					return
 				}
 			}
+			if len(data) != 0 {
+				batch = append(batch, data)
+				types = append(types, typ)
+				size += len(data)
+			}
+			if m.callback != nil {
+				callbacks = append(callbacks, m)
+			}
+		}
 
-		default:
-			if this.IsClosed() {
+		if len(batch) == 0 {
+			for _, c := range callbacks {
+				c.done(nil)
+			}
+			if len(callbacks) != 0 {
+				continue
+			} else if this.IsClosed() {
 				return
 			} else {
 				// 等待发送事件
 				<-this.sendNotifyCh
+				continue
 			}
 		}
 
+		// 发送的消息
+		if this.opts.WriteTimeout > 0 {
+			if err := this.conn.SetWriteDeadline(time.Now().Add(this.opts.WriteTimeout)); err != nil {
+				if this.opts.ErrorCallback != nil {
+					this.opts.ErrorCallback(this, err)
+				}
+			}
+		}
+
+		var err error
+		if writer != nil {
+			// 按消息类型逐条发送
+			for i := range batch {
+				if err = writer.WriteMessage(types[i], batch[i]); err != nil {
+					break
+				}
+			}
+		} else {
+			// WriteTo 会修改切片，使用副本
+			buffers := batch
+			_, err = buffers.WriteTo(this.conn)
+		}
+		for i := range batch {
+			batch[i] = nil
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok {
+				if ne.Timeout() {
+					err = ErrSendTimeout
+				}
+			}
+		}
+		for _, c := range callbacks {
+			c.done(err)
+		}
+		if err != nil {
+			if !this.IsClosed() {
+				if this.opts.ErrorCallback != nil {
+					this.opts.ErrorCallback(this, err)
+				}
+				this.Close(err)
+			}
+			return
+		}
+	}
+}
+
+// limit applies the rate limit to the message just decoded, it returns false if the message is dropped.
+func (this *session) limit(limiter *rateLimiter) bool {
+	n := 0
+	if limiter.count != nil {
+		n, limiter.count.n = limiter.count.n, 0
+	}
+	wait, exceeded := limiter.limit(n, limiter.action == RateLimitDelay)
+	if !exceeded {
+		return true
+	}
+	if this.opts.ErrorCallback != nil {
+		this.opts.ErrorCallback(this, ErrRateLimited)
+	}
+
+	switch limiter.action {
+	case RateLimitDrop:
+		return false
+	case RateLimitClose:
+		this.Close(ErrRateLimited)
+		return false
+	default:
+		// 等待期间不读取连接
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-this.chClose:
+			return false
+		}
+	}
+}
+
+func (this *session) codec() Codec {
+	if len(this.opts.OutboundInterceptors) != 0 {
+		return nil
 	}
+	return this.opts.Codec
+}
+
+func (this *session) sendEncoded(o interface{}, data []byte) error {
+	return this.send(nil, outMessage{msg: o, data: data, prio: PriorityNormal})
 }
 
 func (this *session) Send(o interface{}) error {
 	if o == nil {
 		return ErrSendMsgNil
 	}
+	return this.intercept(nil, outMessage{msg: o, prio: PriorityNormal})
+}
 
-	if this.IsClosed() {
-		return ErrSessionClosed
+// SendPriority sends the message in the lane of prio, Send uses PriorityNormal.
+// The higher lanes are written first, and a waiting lower lane gets one message written
+// after every Options.PriorityShare messages of the higher lanes.
+func (this *session) SendPriority(o interface{}, prio Priority) error {
+	if o == nil {
+		return ErrSendMsgNil
+	}
+	if prio < PriorityHigh || prio > PriorityLow {
+		return ErrInvalidPriority
 	}
+	return this.intercept(nil, outMessage{msg: o, prio: prio})
+}
 
-	if !this.opts.BlockSend {
-		if len(this.sendMessageCh) == this.opts.SendChannelSize {
-			return ErrSendChanFull
-		}
+// SendContext sends the message, it waits for the space of the send queue until ctx is done.
+func (this *session) SendContext(ctx context.Context, o interface{}) error {
+	if o == nil {
+		return ErrSendMsgNil
+	}
+	return this.intercept(ctx, outMessage{msg: o, prio: PriorityNormal})
+}
+
+// SendCallback sends the message, callback is called with nil after the message is written to the connection,
+// or with the error if it fails to be encoded or written. callback is not called if SendCallback returns an error.
+func (this *session) SendCallback(o interface{}, callback func(err error)) error {
+	if o == nil {
+		return ErrSendMsgNil
+	}
+	return this.intercept(nil, outMessage{msg: o, prio: PriorityNormal, callback: callback})
+}
+
+// intercept passes the message through OutboundInterceptors, and puts it in the send queue
+func (this *session) intercept(ctx context.Context, m outMessage) error {
+	return outbound(this.opts.OutboundInterceptors, this, m.msg, func(_ Session, o interface{}) error {
+		m.msg = o
+		return this.send(ctx, m)
+	})
+}
+
+// send puts the message in the send queue, and applies OverflowPolicy if the queue is full.
+// With OverflowReject it waits for the space until ctx is done if ctx is not nil, or else it follows BlockSend.
+func (this *session) send(ctx context.Context, m outMessage) error {
+	if this.IsClosed() {
+		return ErrSessionClosed
 	}
 
 	this.sendOnce.Do(func() {
-		this.sendMessageCh = make(chan interface{}, this.opts.SendChannelSize)
 		this.waitGroup.Add(1)
 		go this.writeThread()
 	})
 
-	this.sendMessageCh <- o
-	sendNotifyChan(this.sendNotifyCh)
+	if this.opts.OverflowPolicy == OverflowCoalesce && this.opts.CoalesceKey != nil && m.msg != nil {
+		m.key = this.opts.CoalesceKey(m.msg)
+	}
 
-	return nil
+	q := this.sendQueue
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return ErrSessionClosed
+		}
+		if m.key != nil {
+			// 替换排队中的旧消息，只发送最新的
+			if old, ok := q.replace(m); ok {
+				q.lock.Unlock()
+				old.done(ErrSendDropped)
+				return nil
+			}
+		}
+		if !q.full() {
+			q.push(m)
+			q.lock.Unlock()
+			sendNotifyChan(this.sendNotifyCh)
+			return nil
+		}
+
+		switch this.opts.OverflowPolicy {
+		case OverflowDropOldest:
+			old, _ := q.dropOldest()
+			q.push(m)
+			q.lock.Unlock()
+			old.done(ErrSendDropped)
+			sendNotifyChan(this.sendNotifyCh)
+			return nil
+		case OverflowClose:
+			q.lock.Unlock()
+			if this.opts.ErrorCallback != nil {
+				this.opts.ErrorCallback(this, ErrSlowConsumer)
+			}
+			// 不再等待发送完毕
+			this.forceClose(ErrSlowConsumer)
+			return ErrSlowConsumer
+		}
+
+		if ctx == nil && !this.opts.BlockSend {
+			q.lock.Unlock()
+			return ErrSendChanFull
+		}
+		space := q.wait()
+		q.lock.Unlock()
+
+		if ctx != nil {
+			select {
+			case <-space:
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-this.chClose:
+				return ErrSessionClosed
+			}
+		} else {
+			select {
+			case <-space:
+			case <-this.chClose:
+				return ErrSessionClosed
+			}
+		}
+	}
 }
 
 /*
- 主动关闭连接
- 先关闭读，待写发送完毕关闭写
+主动关闭连接
+先关闭读，待写发送完毕关闭写
 */
 func (this *session) Close(reason error) {
 	if atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		this.lock.Lock()
+		this.closeReason = reason
+		this.lock.Unlock()
+
 		close(this.chClose)
-		//_ = this.conn.(*net.TCPConn).CloseRead()
+		// 关闭读，唤醒阻塞中的 Decode
+		_ = this.conn.SetReadDeadline(time.Now())
 		// 触发循环
 		sendNotifyChan(this.sendNotifyCh)
 
+		this.lock.Lock()
+		if this.heartbeatTimer != nil {
+			this.heartbeatTimer.Stop()
+		}
+		this.lock.Unlock()
+
 		go func() {
 			this.waitGroup.Wait()
+			this.writeClose(this.reason())
 			_ = this.conn.Close()
+			this.dropQueue()
+
+			reason := this.reason()
+			if this.opts.Hub != nil {
+				this.opts.Hub.Remove(this)
+			}
 			if this.opts.CloseCallback != nil {
 				this.opts.CloseCallback(this, reason)
 			}
+			if this.slot != nil {
+				this.slot.remove(this)
+			}
 		}()
 	}
 }
 
+func (this *session) reason() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.closeReason
+}
+
+// dropQueue fails the callbacks of the messages left in the send queue
+func (this *session) dropQueue() {
+	for _, m := range this.sendQueue.close() {
+		m.done(ErrSessionClosed)
+	}
+}
+
+// forceClose closes the connection at once without waiting for the send queue,
+// reason replaces the one given to Close.
+func (this *session) forceClose(reason error) {
+	this.Close(reason)
+	this.lock.Lock()
+	this.closeReason = reason
+	this.lock.Unlock()
+	_ = this.conn.Close()
+}
+
 // 作为通知用的 channel， make(chan struct{}, 1)
 func sendNotifyChan(ch chan struct{}) {
 	select {
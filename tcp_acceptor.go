@@ -1,23 +1,32 @@
 package dnet
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
+	"log"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type TCPAcceptor struct {
-	address  string
-	listener net.Listener
-	started  int32
+	address   string
+	opts      *AcceptorOptions
+	tlsConfig *tls.Config
+	listener  net.Listener
+	lock      sync.Mutex
+	tracker   *connTracker
+	started   int32
 }
 
 // NewTCPAcceptor returns a new instance of TCPAcceptor
-func NewTCPAcceptor(address string) *TCPAcceptor {
-	return &TCPAcceptor{address: address}
+func NewTCPAcceptor(address string, options ...AcceptorOption) *TCPAcceptor {
+	opts := loadAcceptorOptions(options...)
+	return &TCPAcceptor{address: address, opts: opts, tracker: newConnTracker(opts)}
 }
 
 // ServeTCP listen and serve tcp address with AcceptorHandler
@@ -40,16 +49,43 @@ func (this *TCPAcceptor) Serve(handler AcceptorHandler) error {
 		return errors.New("dnet:Serve acceptor is already started. ")
 	}
 
+	config, err := this.opts.loadTLSConfig()
+	if err != nil {
+		atomic.StoreInt32(&this.started, 0)
+		return errors.New("dnet:Serve load TLS certificate failed, " + err.Error())
+	}
+	this.tlsConfig = config
+
 	listener, err := net.Listen("tcp", this.address)
 	if err != nil {
 		return err
 	}
+	this.lock.Lock()
+	if atomic.LoadInt32(&this.started) == 0 {
+		// 已经调用 Stop
+		this.lock.Unlock()
+		_ = listener.Close()
+		return io.EOF
+	}
 	this.listener = listener
+	this.lock.Unlock()
 	defer this.Stop()
 
+	return acceptLoop(listener, func(conn net.Conn) {
+		ip := remoteIP(conn.RemoteAddr())
+		if err := this.tracker.acquire(ip); err != nil {
+			go this.tracker.refuse(conn, err)
+			return
+		}
+		go this.handle(conn, ip, handler)
+	})
+}
+
+// acceptLoop accepts the connections until the listener is closed, it returns io.EOF if the listener is closed.
+func acceptLoop(listener net.Listener, accept func(conn net.Conn)) error {
 	var tempDelay time.Duration
 	for {
-		conn, err := this.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
@@ -68,10 +104,32 @@ func (this *TCPAcceptor) Serve(handler AcceptorHandler) error {
 			}
 			return err
 		}
+		accept(conn)
+	}
+}
 
-		go handler.OnConnection(conn)
+// handle does the TLS handshake if TLSConfig is set, then invokes handler
+func (this *TCPAcceptor) handle(conn net.Conn, ip string, handler AcceptorHandler) {
+	if this.tlsConfig != nil {
+		tlsConn := this.wrapTLS(conn).(*tls.Conn)
+		_ = tlsConn.SetDeadline(time.Now().Add(this.opts.handshakeTimeout()))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Printf("dnet:Serve TLS handshake failed, %s\n", err.Error())
+			_ = tlsConn.Close()
+			this.tracker.release(ip)
+			return
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
 	}
+	this.tracker.handle(conn, ip, handler)
+}
 
+func (this *TCPAcceptor) wrapTLS(conn net.Conn) net.Conn {
+	if this.tlsConfig == nil {
+		return conn
+	}
+	return tls.Server(conn, this.tlsConfig)
 }
 
 // ServeFunc listens and serve in the specified addr
@@ -81,16 +139,43 @@ func (this *TCPAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
 
 // Addr returns the addr the acceptor will listen on
 func (this *TCPAcceptor) Addr() net.Addr {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.listener == nil {
+		return nil
+	}
 	return this.listener.Addr()
 }
 
 // Stop stops the acceptor
 func (this *TCPAcceptor) Stop() {
-	if atomic.CompareAndSwapInt32(&this.started, 1, 0) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if atomic.CompareAndSwapInt32(&this.started, 1, 0) && this.listener != nil {
 		_ = this.listener.Close()
 	}
 }
 
+// Connections returns the number of connections
+func (this *TCPAcceptor) Connections() int {
+	n, _ := this.tracker.count("")
+	return n
+}
+
+// ConnectionsByIP returns the number of connections from ip
+func (this *TCPAcceptor) ConnectionsByIP(ip string) int {
+	_, n := this.tracker.count(ip)
+	return n
+}
+
+// Shutdown stops the acceptor, and closes the sessions built on the accepted connections
+// after their send queues are drained. The sessions still open when ctx is done are force closed.
+// The sessions built on the connections after OnConnection returns are tracked too.
+func (this *TCPAcceptor) Shutdown(ctx context.Context) error {
+	this.Stop()
+	return this.tracker.shutdown(ctx)
+}
+
 // DialTCP
 func DialTCP(address string, timeout time.Duration) (net.Conn, error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
@@ -101,3 +186,10 @@ func DialTCP(address string, timeout time.Duration) (net.Conn, error) {
 	dialer := &net.Dialer{Timeout: timeout}
 	return dialer.Dial(tcpAddr.Network(), address)
 }
+
+// DialTLS connects to the address with TLS, the handshake is done within timeout.
+// The ServerName of config is taken from address if it is empty.
+func DialTLS(address string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", address, config)
+}
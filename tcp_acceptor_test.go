@@ -1,7 +1,9 @@
 package dnet
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"testing"
@@ -60,3 +62,200 @@ func TestNewTCPAcceptor(t *testing.T) {
 	time.Sleep(time.Millisecond * 500)
 
 }
+
+func TestTCPAcceptorShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	const count = 100
+	reasons := make(chan error, 1)
+	acceptor := NewTCPAcceptor(address)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		session := NewTCPSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {}),
+			WithCloseCallback(func(session Session, reason error) {
+				reasons <- reason
+			}))
+		for i := 0; i < count; i++ {
+			_ = session.Send([]byte{byte(i)})
+		}
+	})
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := acceptor.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-reasons; reason != ErrAcceptorShutdown {
+		t.Fatalf("close reason %v", reason)
+	}
+
+	// 关闭前队列中的消息全部发出
+	for i := 0; i < count; i++ {
+		msg, err := DefTCPCodec{}.Decode(conn)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if msg.([]byte)[0] != byte(i) {
+			t.Fatalf("message %d mismatch", i)
+		}
+	}
+	if _, err := (DefTCPCodec{}).Decode(conn); err != io.EOF {
+		t.Fatalf("want EOF, got %v", err)
+	}
+}
+
+func TestTCPAcceptorShutdownAsyncSession(t *testing.T) {
+	acceptor := NewTCPAcceptor("127.0.0.1:0")
+	reasons := make(chan error, 1)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		// OnConnection 返回后才建立会话
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			NewTCPSession(conn,
+				WithMessageCallback(func(session Session, message interface{}) {}),
+				WithCloseCallback(func(session Session, reason error) {
+					reasons <- reason
+				}))
+		}()
+	})
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := DialTCP(acceptor.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 200)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := acceptor.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-reasons:
+		if reason != ErrAcceptorShutdown {
+			t.Fatalf("close reason %v", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session not closed by Shutdown")
+	}
+}
+
+func TestTCPAcceptorMaxConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	acceptor := NewTCPAcceptor(address,
+		WithMaxConnections(2, 0),
+		WithRefuseCallback(func(conn net.Conn, reason error) {
+			data, _ := DefTCPCodec{}.Encode([]byte("full"))
+			_, _ = conn.Write(data)
+		}))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conns := make([]net.Conn, 0, 2)
+	for i := 0; i < 2; i++ {
+		conn, err := DialTCP(address, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitConnections := func(n int) {
+		for i := 0; acceptor.Connections() != n; i++ {
+			if i > 100 {
+				t.Fatalf("connections %d, want %d", acceptor.Connections(), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitConnections(2)
+	if n := acceptor.ConnectionsByIP("127.0.0.1"); n != 2 {
+		t.Fatalf("connections by ip %d, want 2", n)
+	}
+
+	// 超出上限，收到 full 后关闭
+	conn, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg, err := DefTCPCodec{}.Decode(conn)
+	if err != nil || string(msg.([]byte)) != "full" {
+		t.Fatalf("refused %v %v", msg, err)
+	}
+	if _, err = (DefTCPCodec{}).Decode(conn); err != io.EOF {
+		t.Fatalf("refused conn not closed, %v", err)
+	}
+
+	_ = conns[0].Close()
+	waitConnections(1)
+	if n := acceptor.ConnectionsByIP("127.0.0.1"); n != 1 {
+		t.Fatalf("connections by ip %d, want 1", n)
+	}
+}
+
+func TestTCPAcceptorConnectionCount(t *testing.T) {
+	acceptor := NewTCPAcceptor("127.0.0.1:0", WithMaxConnections(1, 0))
+	closeConn := make(chan struct{})
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		// OnConnection 返回后连接仍然计数，直到关闭
+		go func() {
+			<-closeConn
+			_ = conn.Close()
+		}()
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := DialTCP(acceptor.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	if n := acceptor.Connections(); n != 1 {
+		t.Fatalf("connections %d, want 1", n)
+	}
+
+	// 超出上限
+	refused, err := DialTCP(acceptor.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	if _, err = (DefTCPCodec{}).Decode(refused); err != io.EOF {
+		t.Fatalf("refused conn not closed, %v", err)
+	}
+
+	close(closeConn)
+	for i := 0; acceptor.Connections() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("connections %d after close, want 0", acceptor.Connections())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
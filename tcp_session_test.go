@@ -1,6 +1,7 @@
 package dnet
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"testing"
@@ -152,3 +153,104 @@ func TestTCP(t *testing.T) {
 	time.Sleep(time.Second * 1)
 
 }
+
+func TestTCPSessionSendBatch(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	const count = 1000
+	recv := make(chan []byte, count)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			recv <- message.([]byte)
+		}))
+	}()
+
+	conn, err := DialTCP(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	session := NewTCPSession(conn,
+		WithSendBatch(16, 256),
+		WithMessageCallback(func(session Session, message interface{}) {}))
+	for i := 0; i < count; i++ {
+		if err := session.Send([]byte{byte(i), byte(i >> 8)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-recv:
+			if int(msg[0])|int(msg[1])<<8 != i {
+				t.Fatalf("message %d out of order", i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("recv timeout, got %d", i)
+		}
+	}
+}
+
+func TestSessionSendContext(t *testing.T) {
+	// net.Pipe 没有缓冲，对端不读时写阻塞
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	closed := make(chan struct{})
+	session := NewTCPSession(c1,
+		WithSendChannelSize(1),
+		WithMessageCallback(func(session Session, message interface{}) {}),
+		WithCloseCallback(func(session Session, reason error) { close(closed) }))
+
+	written := make(chan error, 3)
+	for i := 0; i < 2; i++ {
+		if err := session.SendCallback([]byte{byte(i)}, func(err error) { written <- err }); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := session.SendContext(ctx, []byte{2}); err != context.DeadlineExceeded {
+		t.Fatalf("SendContext %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 读出第一条消息，回调成功
+	go func() {
+		buf := make([]byte, 3)
+		_, _ = c2.Read(buf)
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback timeout")
+	}
+
+	// 关闭连接，写失败
+	_ = c2.Close()
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Fatal("callback succeeded after the peer closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback timeout")
+	}
+	<-closed
+	if err := session.SendContext(context.Background(), []byte{3}); err != ErrSessionClosed {
+		t.Fatalf("SendContext %v, want %v", err, ErrSessionClosed)
+	}
+}
@@ -0,0 +1,246 @@
+package dnet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificates returns a CA pool, and the server and client certificates signed by the CA.
+func testCertificates(t *testing.T) (*x509.CertPool, tls.Certificate, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dnet ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	return pool, issue(2, "server", x509.ExtKeyUsageServerAuth), issue(3, "client", x509.ExtKeyUsageClientAuth)
+}
+
+func TestTCPAcceptorTLS(t *testing.T) {
+	pool, serverCert, clientCert := testCertificates(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	peers := make(chan string, 1)
+	acceptor := NewTCPAcceptor(address, WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+		if certs := session.PeerCertificates(); len(certs) > 0 {
+			peers <- certs[0].Subject.CommonName
+		} else {
+			peers <- ""
+		}
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 没有客户端证书
+	conn, err := DialTLS(address, &tls.Config{RootCAs: pool}, time.Second)
+	if err == nil {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil {
+		t.Fatal("handshake without client certificate succeeded")
+	}
+
+	conn, err = DialTLS(address, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	recv := make(chan []byte, 1)
+	session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		recv <- message.([]byte)
+	}))
+	if certs := session.PeerCertificates(); len(certs) == 0 || certs[0].Subject.CommonName != "server" {
+		t.Fatalf("server certificates %v", certs)
+	}
+	if err := session.Send([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case name := <-peers:
+		if name != "client" {
+			t.Fatalf("client certificate %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept timeout")
+	}
+	select {
+	case msg := <-recv:
+		if len(msg) != 3 {
+			t.Fatalf("echo %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("echo timeout")
+	}
+}
+
+func TestWSAcceptorTLS(t *testing.T) {
+	pool, serverCert, _ := testCertificates(t)
+
+	// 证书和私钥写入文件
+	dir, err := ioutil.TempDir("", "dnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := x509.MarshalECPrivateKey(serverCert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	acceptor := NewWSAcceptor(address, WithTLSCertFile(certFile, keyFile))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := DialWS(address, time.Second); err == nil {
+		t.Fatal("dial wss server with ws succeeded")
+	}
+
+	dialer := &WSDialer{
+		TLSConfig:        &tls.Config{RootCAs: pool},
+		Header:           http.Header{"Origin": []string{"https://127.0.0.1"}},
+		Path:             "/ws",
+		HandshakeTimeout: time.Second,
+	}
+	conn, err := dialer.Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	recv := make(chan []byte, 1)
+	session := NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		recv <- message.([]byte)
+	}))
+	if certs := session.PeerCertificates(); len(certs) == 0 || certs[0].Subject.CommonName != "server" {
+		t.Fatalf("server certificates %v", certs)
+	}
+	if err := session.Send([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recv:
+		if len(msg) != 3 {
+			t.Fatalf("echo %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("echo timeout")
+	}
+}
+
+func TestWSAcceptorTLSIPFilter(t *testing.T) {
+	pool, serverCert, _ := testCertificates(t)
+	filter := NewIPFilter()
+	if err := filter.SetDeny("127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	acceptor := NewWSAcceptor("127.0.0.1:0",
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}),
+		WithIPFilter(filter),
+		WithRefuseCallback(func(conn net.Conn, reason error) {
+			t.Errorf("refuse callback called with %v", reason)
+		}))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		t.Error("denied ip accepted")
+		conn.Close()
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 不进行 TLS 握手，直接关闭
+	conn, err := DialTLS(acceptor.Addr().String(), &tls.Config{RootCAs: pool}, time.Second)
+	if err == nil {
+		conn.Close()
+		t.Fatal("denied ip handshaked")
+	}
+	if _, err := DialWSS(acceptor.Addr().String(), &tls.Config{RootCAs: pool}, time.Second); err == nil {
+		t.Fatal("denied ip upgraded")
+	}
+}
@@ -0,0 +1,351 @@
+package dnet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	udpSynInterval    = 100 * time.Millisecond
+	udpCookieSize     = 8
+	udpCookieLifetime = 30 // 秒
+)
+
+// UDPAcceptor serves the reliable UDP connections on a UDP socket, see UDPConn.
+// The connections are demultiplexed by the remote address.
+// The SYN is answered with a stateless cookie, the connection is created after the client returns it,
+// so a SYN from a spoofed address allocates nothing and can't replace the connection of the address.
+// The connection limits and IPFilter are checked before the connection is created, DialUDP of a refused
+// client fails with ECONNREFUSED, and RefuseCallback is not called.
+type UDPAcceptor struct {
+	address   string
+	opts      *AcceptorOptions
+	socket    *net.UDPConn
+	secret    [32]byte // cookie 的密钥
+	lock      sync.Mutex
+	conns     map[string]*UDPConn
+	tracker   *connTracker
+	started   int32
+	accepting int32
+}
+
+// NewUDPAcceptor returns a new instance of UDPAcceptor
+func NewUDPAcceptor(address string, options ...AcceptorOption) *UDPAcceptor {
+	opts := loadAcceptorOptions(options...)
+	return &UDPAcceptor{address: address, opts: opts, conns: map[string]*UDPConn{}, tracker: newConnTracker(opts)}
+}
+
+// ServeUDP listen and serve udp address with AcceptorHandler
+func ServeUDP(address string, handler AcceptorHandler) error {
+	return NewUDPAcceptor(address).Serve(handler)
+}
+
+// ServeUDPFunc listen and serve udp address with AcceptorHandlerFunc
+func ServeUDPFunc(address string, handler AcceptorHandlerFunc) error {
+	return NewUDPAcceptor(address).ServeFunc(handler)
+}
+
+// Serve listens and serve in the specified addr
+func (this *UDPAcceptor) Serve(handler AcceptorHandler) error {
+	if handler == nil {
+		return errors.New("dnet:Serve handler is nil. ")
+	}
+
+	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
+		return errors.New("dnet:Serve acceptor is already started. ")
+	}
+
+	if _, err := rand.Read(this.secret[:]); err != nil {
+		atomic.StoreInt32(&this.started, 0)
+		return err
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", this.address)
+	if err != nil {
+		atomic.StoreInt32(&this.started, 0)
+		return err
+	}
+	socket, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		atomic.StoreInt32(&this.started, 0)
+		return err
+	}
+	this.lock.Lock()
+	if atomic.LoadInt32(&this.started) == 0 {
+		// 已经调用 Stop
+		this.lock.Unlock()
+		_ = socket.Close()
+		return io.EOF
+	}
+	this.socket = socket
+	atomic.StoreInt32(&this.accepting, 1)
+	this.lock.Unlock()
+	defer this.Stop()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := socket.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return io.EOF
+			}
+			return err
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+
+		conv := binary.BigEndian.Uint32(buf[1:])
+		key := addr.String()
+		this.lock.Lock()
+		conn := this.conns[key]
+		this.lock.Unlock()
+
+		switch {
+		case buf[0] == udpSyn:
+			this.synAck(socket, conv, addr, buf[udpHeaderSize:n])
+		case buf[0] == udpCookie:
+			this.accept(socket, conn, conv, addr, buf[udpHeaderSize:n], handler)
+		case conn != nil && conn.conv == conv:
+			conn.input(buf[:n])
+		}
+	}
+}
+
+// cookie returns the cookie of the handshake from addr with conv in the time slot
+func (this *UDPAcceptor) cookie(addr *net.UDPAddr, conv uint32, slot int64) []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[:], conv)
+	binary.BigEndian.PutUint64(b[4:], uint64(slot))
+	mac := hmac.New(sha256.New, this.secret[:])
+	mac.Write(b[:])
+	mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)[:udpCookieSize]
+}
+
+// synAck replies the SYN with the cookie, no state is kept.
+// The SYN is padded to the size of the SYN-ACK, so the reply is not larger than the request.
+func (this *UDPAcceptor) synAck(socket *net.UDPConn, conv uint32, addr *net.UDPAddr, payload []byte) {
+	if len(payload) < udpCookieSize || atomic.LoadInt32(&this.accepting) == 0 {
+		return
+	}
+	synAck := udpPacket(udpSynAck, conv, udpCookieSize)
+	copy(synAck[udpHeaderSize:], this.cookie(addr, conv, time.Now().Unix()/udpCookieLifetime))
+	_, _ = socket.WriteToUDP(synAck, addr)
+}
+
+// verifyCookie reports whether cookie is issued to addr with conv in the current or the last time slot
+func (this *UDPAcceptor) verifyCookie(addr *net.UDPAddr, conv uint32, cookie []byte) bool {
+	if len(cookie) < udpCookieSize {
+		return false
+	}
+	slot := time.Now().Unix() / udpCookieLifetime
+	return hmac.Equal(cookie[:udpCookieSize], this.cookie(addr, conv, slot)) ||
+		hmac.Equal(cookie[:udpCookieSize], this.cookie(addr, conv, slot-1))
+}
+
+// accept verifies the returned cookie, and invokes handler with the new connection
+func (this *UDPAcceptor) accept(socket *net.UDPConn, conn *UDPConn, conv uint32, addr *net.UDPAddr, cookie []byte, handler AcceptorHandler) {
+	if !this.verifyCookie(addr, conv, cookie) {
+		return
+	}
+	ready := udpPacket(udpReady, conv, 0)
+	if conn != nil {
+		if conn.conv == conv {
+			// 重传的 cookie
+			_, _ = socket.WriteToUDP(ready, addr)
+			return
+		}
+		// 对端重新连接
+		conn.lock.Lock()
+		conn.finish(io.EOF)
+		conn.lock.Unlock()
+	}
+	if atomic.LoadInt32(&this.accepting) == 0 {
+		return
+	}
+
+	// 先检查连接数和 ip，被拒绝的对端不创建连接，回复 FIN
+	ip := addr.IP.String()
+	if err := this.tracker.acquire(ip); err != nil {
+		_, _ = socket.WriteToUDP(udpPacket(udpFin, conv, 0), addr)
+		return
+	}
+
+	key := addr.String()
+	this.lock.Lock()
+	conn = newUDPConn(conv, socket.LocalAddr(), addr, func(b []byte) error {
+		_, err := socket.WriteToUDP(b, addr)
+		return err
+	}, func() {
+		this.lock.Lock()
+		if this.conns[key] == conn {
+			delete(this.conns, key)
+		}
+		this.lock.Unlock()
+	})
+	this.conns[key] = conn
+	this.lock.Unlock()
+	if this.opts.MaxMessageSize > 0 {
+		conn.SetReadLimit(this.opts.MaxMessageSize)
+	}
+	_, _ = socket.WriteToUDP(ready, addr)
+	go this.tracker.handle(conn, ip, handler)
+}
+
+// ServeFunc listens and serve in the specified addr
+func (this *UDPAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
+	return this.Serve(handler)
+}
+
+// Addr returns the addr the acceptor will listen on
+func (this *UDPAcceptor) Addr() net.Addr {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.socket == nil {
+		return nil
+	}
+	return this.socket.LocalAddr()
+}
+
+// Stop stops the acceptor, and closes the connections
+func (this *UDPAcceptor) Stop() {
+	this.lock.Lock()
+	if !atomic.CompareAndSwapInt32(&this.started, 1, 0) || this.socket == nil {
+		this.lock.Unlock()
+		return
+	}
+	atomic.StoreInt32(&this.accepting, 0)
+	_ = this.socket.Close()
+	conns := make([]*UDPConn, 0, len(this.conns))
+	for _, conn := range this.conns {
+		conns = append(conns, conn)
+	}
+	this.lock.Unlock()
+
+	for _, conn := range conns {
+		conn.lock.Lock()
+		conn.finish(io.ErrClosedPipe)
+		conn.lock.Unlock()
+	}
+}
+
+// Connections returns the number of connections
+func (this *UDPAcceptor) Connections() int {
+	n, _ := this.tracker.count("")
+	return n
+}
+
+// ConnectionsByIP returns the number of connections from ip
+func (this *UDPAcceptor) ConnectionsByIP(ip string) int {
+	_, n := this.tracker.count(ip)
+	return n
+}
+
+// Shutdown stops accepting, and closes the sessions built on the accepted connections
+// after their send queues are drained. The sessions still open when ctx is done are force closed.
+// The UDP socket is closed at last, so the connections are closed after the sessions.
+func (this *UDPAcceptor) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&this.accepting, 0)
+	err := this.tracker.shutdown(ctx)
+	this.Stop()
+	return err
+}
+
+// DialUDP connects to the UDPAcceptor at address, the handshake is done within timeout.
+func DialUDP(address string, timeout time.Duration) (net.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	socket, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		_ = socket.Close()
+		return nil, err
+	}
+	conv := binary.BigEndian.Uint32(b[:])
+
+	if timeout <= 0 {
+		timeout = defHandshakeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	// SYN -> SYN-ACK(cookie) -> cookie -> READY
+	request := udpPacket(udpSyn, conv, udpCookieSize)
+	buf := make([]byte, 64*1024)
+	for {
+		if !time.Now().Before(deadline) {
+			_ = socket.Close()
+			return nil, udpTimeoutError{}
+		}
+		if _, err := socket.Write(request); err != nil {
+			_ = socket.Close()
+			return nil, err
+		}
+		wait := time.Now().Add(udpSynInterval)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		_ = socket.SetReadDeadline(wait)
+		n, err := socket.Read(buf)
+		if err != nil || n < udpHeaderSize || binary.BigEndian.Uint32(buf[1:]) != conv {
+			continue
+		}
+		if buf[0] == udpSynAck && n >= udpHeaderSize+udpCookieSize {
+			request = udpPacket(udpCookie, conv, udpCookieSize)
+			copy(request[udpHeaderSize:], buf[udpHeaderSize:n])
+		} else if buf[0] == udpReady && request[0] == udpCookie {
+			break
+		} else if buf[0] == udpFin && request[0] == udpCookie {
+			// 服务端拒绝了连接
+			_ = socket.Close()
+			return nil, &net.OpError{Op: "dial", Net: "udp", Addr: udpAddr, Err: syscall.ECONNREFUSED}
+		}
+	}
+	_ = socket.SetReadDeadline(time.Time{})
+
+	conn := newUDPConn(conv, socket.LocalAddr(), udpAddr, func(b []byte) error {
+		_, err := socket.Write(b)
+		return err
+	}, func() {
+		_ = socket.Close()
+	})
+	go func() {
+		for {
+			n, err := socket.Read(buf)
+			if err != nil {
+				select {
+				case <-conn.chDone:
+					return
+				default:
+				}
+				if strings.Contains(err.Error(), "use of closed network connection") {
+					return
+				}
+				// 对端未监听时的 ICMP 错误
+				continue
+			}
+			if n >= udpHeaderSize && binary.BigEndian.Uint32(buf[1:]) == conv {
+				conn.input(buf[:n])
+			}
+		}
+	}()
+	return conn, nil
+}
@@ -0,0 +1,798 @@
+package dnet
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// the channels of the UDP messages
+const (
+	ReliableChannel   = 1 // 可靠有序
+	UnreliableChannel = 2 // 不可靠，可能丢失或乱序
+)
+
+const (
+	udpMTU            = 1200 // 数据包的最大长度
+	udpHeaderSize     = 5    // type + conv
+	udpDataHeaderSize = udpHeaderSize + 6
+	udpMSS            = udpMTU - udpDataHeaderSize // 可靠分片的最大长度
+	udpMaxUnreliable  = udpMTU - udpHeaderSize     // 不可靠消息的最大长度
+	udpMaxFragments   = 1 << 16
+	udpDefReadLimit   = 4 << 20 // 默认的可靠消息最大长度，限制重组消息占用的内存
+
+	udpWindow        = 512 // 发送和接收窗口，分片数
+	udpSendQueueSize = 2 * udpWindow
+	udpMaxQueued     = 1024 // 等待读取的不可靠消息数
+	udpMaxSackBlocks = 16
+	udpFinCopies     = 3
+
+	udpInterval   = 10 * time.Millisecond
+	udpMinRTO     = 50 * time.Millisecond
+	udpMaxRTO     = 5 * time.Second
+	udpInitRTO    = 200 * time.Millisecond
+	udpKeepalive  = time.Second
+	udpDeadline   = 10 * time.Second // 超过时间没有收到对端的数据包，连接断开
+	udpLinger     = 5 * time.Second  // Close 后等待发送完成的时间
+	udpInitCwnd   = 4
+	udpInitThresh = udpWindow / 2
+)
+
+// the types of the UDP packets
+const (
+	udpSyn        byte = iota + 1 // conv
+	udpSynAck                     // conv
+	udpData                       // conv, seq, frg, payload
+	udpUnreliable                 // conv, payload
+	udpAck                        // conv, una, wnd, n, [start, end) * n
+	udpFin                        // conv
+	udpCookie                     // conv, cookie: 客户端返回 SYN-ACK 中的 cookie
+	udpReady                      // conv: 服务端建立了连接
+)
+
+// udpSegment is a fragment of the reliable messages,
+// frg is the number of the fragments after it, 0 means the last fragment of a message.
+type udpSegment struct {
+	seq      uint32
+	frg      uint16
+	data     []byte
+	sentAt   time.Time
+	resendAt time.Time
+	xmit     int
+	acked    bool // 被选择确认
+}
+
+type udpMessage struct {
+	channel int
+	data    []byte
+	segs    int
+}
+
+// udpTimeoutError is returned when the deadline of read or write is exceeded
+type udpTimeoutError struct{}
+
+func (udpTimeoutError) Error() string   { return "dnet: udp i/o timeout" }
+func (udpTimeoutError) Timeout() bool   { return true }
+func (udpTimeoutError) Temporary() bool { return true }
+
+// seqBefore reports whether a is before b with wrapping
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// udpPacket returns a packet of typ with the header filled, and size bytes after the header
+func udpPacket(typ byte, conv uint32, size int) []byte {
+	b := make([]byte, udpHeaderSize+size)
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], conv)
+	return b
+}
+
+// UDPConn is a reliable UDP connection, which implements net.Conn.
+// The messages are sent on the reliable ordered channel or the unreliable channel by WriteMessage,
+// the reliable fragments are retransmitted with selective ACK under the congestion control.
+// The connection is closed with ErrHeartbeatTimeout if nothing is received from the peer within 10 seconds.
+type UDPConn struct {
+	conv    uint32
+	local   net.Addr
+	remote  net.Addr
+	output  func(b []byte) error // 发送数据包
+	release func()               // 连接结束后调用
+	slot    *connSlot            // 接收此连接的 acceptor 的计数
+
+	lock sync.Mutex
+
+	// 发送
+	sndNxt   uint32
+	sndUna   uint32
+	sndQueue []*udpSegment // 等待发送
+	sndBuf   []*udpSegment // 已发送未确认
+	rmtWnd   int
+	cwnd     int
+	cwndAcc  int
+	ssthresh int
+	recover  uint32 // 快速恢复结束的序号
+	recovery bool
+	rackSent time.Time // 最近发送的已确认分片的发送时间
+	rackSeq  uint32
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	backoff  uint // 超时重传的退避次数，收到确认时清零
+	lastSend time.Time
+
+	// 接收
+	rcvNxt     uint32
+	rcvBuf     map[uint32]*udpSegment
+	partial    []byte // 正在重组的消息
+	partSegs   int
+	msgs       []udpMessage
+	queuedSegs int // 等待读取的可靠分片数
+	unreliable int // 等待读取的不可靠消息数
+	ackPending bool
+	lastRecv   time.Time
+
+	// 读
+	typ       int
+	reader    []byte
+	readLimit int64
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readable      chan struct{}
+	writable      chan struct{}
+	wakeup        chan struct{}
+
+	err    error // 连接的错误，io.EOF 表示对端关闭
+	closed bool  // 调用了 Close
+	linger time.Time
+	chDone chan struct{}
+}
+
+func newUDPConn(conv uint32, local, remote net.Addr, output func(b []byte) error, release func()) *UDPConn {
+	now := time.Now()
+	c := &UDPConn{
+		conv:      conv,
+		local:     local,
+		remote:    remote,
+		output:    output,
+		release:   release,
+		rmtWnd:    udpWindow,
+		cwnd:      udpInitCwnd,
+		ssthresh:  udpInitThresh,
+		rto:       udpInitRTO,
+		lastSend:  now,
+		rcvBuf:    map[uint32]*udpSegment{},
+		lastRecv:  now,
+		readLimit: udpDefReadLimit,
+		readable:  make(chan struct{}, 1),
+		writable:  make(chan struct{}, 1),
+		wakeup:    make(chan struct{}, 1),
+		chDone:    make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// isDone reports whether the connection is done, lock must be held.
+func (c *UDPConn) isDone() bool {
+	select {
+	case <-c.chDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// run updates the connection every udpInterval until it is done
+func (c *UDPConn) run() {
+	ticker := time.NewTicker(udpInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.wakeup:
+		case <-c.chDone:
+			if c.release != nil {
+				c.release()
+			}
+			c.lock.Lock()
+			slot := c.slot
+			c.lock.Unlock()
+			if slot != nil {
+				slot.release()
+			}
+			return
+		}
+		c.lock.Lock()
+		c.update(time.Now())
+		c.lock.Unlock()
+	}
+}
+
+// wait releases the lock and waits for ch, until the deadline or the connection is done
+func (c *UDPConn) wait(ch chan struct{}, deadline time.Time) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	c.lock.Unlock()
+	select {
+	case <-ch:
+	case <-timeout:
+	case <-c.chDone:
+	}
+	c.lock.Lock()
+}
+
+func (c *UDPConn) send(b []byte, now time.Time) {
+	_ = c.output(b)
+	c.lastSend = now
+}
+
+// finish ends the connection with err, the rest messages can still be read.
+func (c *UDPConn) finish(err error) {
+	select {
+	case <-c.chDone:
+		return
+	default:
+	}
+	if c.err == nil {
+		c.err = err
+	}
+	if err != io.EOF {
+		// 通知对端
+		fin := udpPacket(udpFin, c.conv, 0)
+		for i := 0; i < udpFinCopies; i++ {
+			_ = c.output(fin)
+		}
+	}
+	close(c.chDone)
+	c.sndQueue, c.sndBuf = nil, nil
+}
+
+// update retransmits the lost fragments, sends the waiting fragments and the ACK
+func (c *UDPConn) update(now time.Time) {
+	select {
+	case <-c.chDone:
+		return
+	default:
+	}
+	if now.Sub(c.lastRecv) >= udpDeadline {
+		c.finish(ErrHeartbeatTimeout)
+		return
+	}
+
+	c.detectLoss(now)
+
+	// 超时重传
+	lost := false
+	for _, seg := range c.sndBuf {
+		if !seg.acked && !now.Before(seg.resendAt) {
+			c.sendSegment(seg, now)
+			lost = true
+		}
+	}
+	if lost {
+		c.ssthresh = c.inflight() / 2
+		if c.ssthresh < 2 {
+			c.ssthresh = 2
+		}
+		c.cwnd, c.cwndAcc = 1, 0
+		c.recovery = false
+		if c.rto<<c.backoff < udpMaxRTO {
+			c.backoff++
+		}
+	}
+
+	c.flush(now)
+
+	if c.ackPending || now.Sub(c.lastSend) >= udpKeepalive {
+		c.sendAck(now)
+	}
+
+	if c.closed && ((len(c.sndQueue) == 0 && len(c.sndBuf) == 0) || now.After(c.linger)) {
+		c.finish(io.ErrClosedPipe)
+	}
+}
+
+func (c *UDPConn) inflight() int {
+	return int(c.sndNxt - c.sndUna)
+}
+
+// flush sends the waiting fragments within the window
+func (c *UDPConn) flush(now time.Time) {
+	wnd := c.cwnd
+	if c.rmtWnd < wnd {
+		wnd = c.rmtWnd
+	}
+	if wnd < 1 && len(c.sndBuf) == 0 {
+		// 对端窗口为零时探测
+		wnd = 1
+	}
+	n := 0
+	for n < len(c.sndQueue) && c.inflight() < wnd {
+		seg := c.sndQueue[n]
+		c.sndQueue[n] = nil
+		n++
+		seg.seq = c.sndNxt
+		c.sndNxt++
+		c.sndBuf = append(c.sndBuf, seg)
+		c.sendSegment(seg, now)
+	}
+	if n > 0 {
+		c.sndQueue = c.sndQueue[n:]
+		notify(c.writable)
+	}
+}
+
+func (c *UDPConn) sendSegment(seg *udpSegment, now time.Time) {
+	b := udpPacket(udpData, c.conv, 6+len(seg.data))
+	binary.BigEndian.PutUint32(b[udpHeaderSize:], seg.seq)
+	binary.BigEndian.PutUint16(b[udpHeaderSize+4:], seg.frg)
+	copy(b[udpDataHeaderSize:], seg.data)
+
+	seg.xmit++
+	seg.sentAt = now
+	rto := c.rto << c.backoff
+	if rto > udpMaxRTO {
+		rto = udpMaxRTO
+	}
+	seg.resendAt = now.Add(rto)
+	c.send(b, now)
+}
+
+func (c *UDPConn) window() int {
+	if wnd := udpWindow - c.queuedSegs; wnd > 0 {
+		return wnd
+	}
+	return 0
+}
+
+// sendAck sends the cumulative ACK and the received blocks after it
+func (c *UDPConn) sendAck(now time.Time) {
+	var blocks [][2]uint32
+	if len(c.rcvBuf) > 0 {
+		for seq := c.rcvNxt + 1; seq != c.rcvNxt+udpWindow && len(blocks) < udpMaxSackBlocks; seq++ {
+			if _, ok := c.rcvBuf[seq]; !ok {
+				continue
+			}
+			if n := len(blocks); n > 0 && blocks[n-1][1] == seq {
+				blocks[n-1][1]++
+			} else {
+				blocks = append(blocks, [2]uint32{seq, seq + 1})
+			}
+		}
+	}
+
+	b := udpPacket(udpAck, c.conv, 9+8*len(blocks))
+	binary.BigEndian.PutUint32(b[udpHeaderSize:], c.rcvNxt)
+	binary.BigEndian.PutUint32(b[udpHeaderSize+4:], uint32(c.window()))
+	b[udpHeaderSize+8] = byte(len(blocks))
+	for i, block := range blocks {
+		binary.BigEndian.PutUint32(b[udpHeaderSize+9+8*i:], block[0])
+		binary.BigEndian.PutUint32(b[udpHeaderSize+13+8*i:], block[1])
+	}
+	c.ackPending = false
+	c.send(b, now)
+}
+
+// input handles a packet of the connection
+func (c *UDPConn) input(b []byte) {
+	if len(b) < udpHeaderSize {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.chDone:
+		return
+	default:
+	}
+
+	now := time.Now()
+	c.lastRecv = now
+	payload := b[udpHeaderSize:]
+	switch b[0] {
+	case udpData:
+		if len(payload) < 6 {
+			return
+		}
+		c.inputData(binary.BigEndian.Uint32(payload), binary.BigEndian.Uint16(payload[4:]), payload[6:])
+	case udpUnreliable:
+		if c.closed || c.unreliable >= udpMaxQueued {
+			return
+		}
+		c.unreliable++
+		c.msgs = append(c.msgs, udpMessage{channel: UnreliableChannel, data: append([]byte(nil), payload...)})
+		notify(c.readable)
+	case udpAck:
+		if len(payload) < 9 || len(payload) < 9+8*int(payload[8]) {
+			return
+		}
+		c.inputAck(payload, now)
+	case udpFin:
+		c.finish(io.EOF)
+		notify(c.readable)
+	}
+}
+
+func (c *UDPConn) inputData(seq uint32, frg uint16, data []byte) {
+	// 立即确认，同一轮收到的分片合并确认
+	c.ackPending = true
+	notify(c.wakeup)
+	if seqBefore(seq, c.rcvNxt) {
+		// 重复的分片
+		return
+	}
+	if offset := int(seq - c.rcvNxt); offset >= c.window() {
+		return
+	}
+	if _, ok := c.rcvBuf[seq]; !ok {
+		c.rcvBuf[seq] = &udpSegment{seq: seq, frg: frg, data: append([]byte(nil), data...)}
+	}
+	if seq != c.rcvNxt {
+		return
+	}
+
+	for {
+		seg, ok := c.rcvBuf[c.rcvNxt]
+		if !ok {
+			break
+		}
+		delete(c.rcvBuf, c.rcvNxt)
+		c.rcvNxt++
+		c.partial = append(c.partial, seg.data...)
+		c.partSegs++
+		// 空分片不增加长度，同时限制分片数
+		if int64(len(c.partial)) > c.readLimit || int64(c.partSegs-1)*udpMSS > c.readLimit {
+			c.finish(ErrMessageTooLarge)
+			break
+		}
+		if seg.frg == 0 {
+			c.msgs = append(c.msgs, udpMessage{channel: ReliableChannel, data: c.partial, segs: c.partSegs})
+			c.queuedSegs += c.partSegs
+			c.partial, c.partSegs = nil, 0
+		}
+	}
+	notify(c.readable)
+}
+
+func (c *UDPConn) inputAck(b []byte, now time.Time) {
+	una := binary.BigEndian.Uint32(b)
+	c.rmtWnd = int(binary.BigEndian.Uint32(b[4:]))
+	if seqBefore(c.sndNxt, una) {
+		return
+	}
+
+	acked := 0
+	ack := func(seg *udpSegment) {
+		seg.acked = true
+		acked++
+		if seg.xmit == 1 {
+			c.sample(now.Sub(seg.sentAt))
+		}
+		if seg.sentAt.After(c.rackSent) || seg.sentAt.Equal(c.rackSent) && seqBefore(c.rackSeq, seg.seq) {
+			c.rackSent, c.rackSeq = seg.sentAt, seg.seq
+		}
+	}
+	for _, seg := range c.sndBuf {
+		if seqBefore(seg.seq, una) && !seg.acked {
+			ack(seg)
+		}
+	}
+	for i := 0; i < int(b[8]); i++ {
+		start := binary.BigEndian.Uint32(b[9+8*i:])
+		end := binary.BigEndian.Uint32(b[13+8*i:])
+		for _, seg := range c.sndBuf {
+			if !seg.acked && !seqBefore(seg.seq, start) && seqBefore(seg.seq, end) {
+				ack(seg)
+			}
+		}
+	}
+
+	// 删除已确认的分片
+	n := 0
+	for n < len(c.sndBuf) && c.sndBuf[n].acked {
+		c.sndBuf[n] = nil
+		n++
+	}
+	c.sndBuf = c.sndBuf[n:]
+	if len(c.sndBuf) > 0 {
+		c.sndUna = c.sndBuf[0].seq
+	} else {
+		c.sndUna = c.sndNxt
+	}
+	if c.recovery && !seqBefore(c.sndUna, c.recover) {
+		c.recovery = false
+	}
+
+	c.detectLoss(now)
+
+	// 拥塞窗口增长
+	if acked > 0 && !c.recovery {
+		if c.cwnd < c.ssthresh {
+			c.cwnd += acked
+		} else if c.cwndAcc += acked; c.cwndAcc >= c.cwnd {
+			c.cwndAcc -= c.cwnd
+			c.cwnd++
+		}
+		if c.cwnd > udpWindow {
+			c.cwnd = udpWindow
+		}
+	}
+	if acked > 0 {
+		c.backoff = 0
+	}
+	if acked > 0 || len(c.sndQueue) > 0 {
+		notify(c.wakeup)
+	}
+}
+
+// detectLoss retransmits the fragments sent before an acknowledged one,
+// and not acknowledged within the reordering window, they are lost rather than reordered.
+func (c *UDPConn) detectLoss(now time.Time) {
+	delay := c.srtt + c.srtt/4
+	lost := false
+	for _, seg := range c.sndBuf {
+		if seg.acked || now.Sub(seg.sentAt) < delay {
+			continue
+		}
+		if seg.sentAt.Before(c.rackSent) || seg.sentAt.Equal(c.rackSent) && seqBefore(seg.seq, c.rackSeq) {
+			c.sendSegment(seg, now)
+			lost = true
+		}
+	}
+	if lost && !c.recovery {
+		// 快速恢复，每个窗口减小一次
+		c.recovery = true
+		c.recover = c.sndNxt
+		c.ssthresh = c.inflight() / 2
+		if c.ssthresh < 2 {
+			c.ssthresh = 2
+		}
+		c.cwnd, c.cwndAcc = c.ssthresh, 0
+	}
+}
+
+// sample updates the RTO with a RTT sample, see RFC 6298
+func (c *UDPConn) sample(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = rtt, rtt/2
+	} else {
+		delta := c.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < c.srtt+udpInterval {
+		c.rto = c.srtt + udpInterval
+	}
+	if c.rto < udpMinRTO {
+		c.rto = udpMinRTO
+	} else if c.rto > udpMaxRTO {
+		c.rto = udpMaxRTO
+	}
+}
+
+// RTT returns the smoothed round trip time
+func (c *UDPConn) RTT() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.srtt
+}
+
+// Read reads data from the current message, or the next message.
+func (c *UDPConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if len(c.reader) == 0 {
+		data, err := c.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.reader = data
+	}
+	n := copy(b, c.reader)
+	c.reader = c.reader[n:]
+	return n, nil
+}
+
+// ReadMessage reads the rest of the current message, or the next message.
+// The messages received before the connection ends are read first.
+func (c *UDPConn) ReadMessage() ([]byte, error) {
+	if len(c.reader) > 0 {
+		data := c.reader
+		c.reader = nil
+		return data, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(c.msgs) == 0 {
+		if c.closed {
+			return nil, io.ErrClosedPipe
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		if !c.readDeadline.IsZero() && !time.Now().Before(c.readDeadline) {
+			return nil, udpTimeoutError{}
+		}
+		c.wait(c.readable, c.readDeadline)
+	}
+
+	m := c.msgs[0]
+	c.msgs[0] = udpMessage{}
+	c.msgs = c.msgs[1:]
+	c.typ = m.channel
+	if m.channel == UnreliableChannel {
+		c.unreliable--
+	} else {
+		closed := c.window() == 0
+		c.queuedSegs -= m.segs
+		if closed && c.window() > 0 {
+			// 窗口更新
+			c.ackPending = true
+			notify(c.wakeup)
+		}
+	}
+	if m.data == nil {
+		m.data = []byte{}
+	}
+	return m.data, nil
+}
+
+// MessageType returns the channel of the message being read, ReliableChannel or UnreliableChannel.
+// Call it in Codec.Decode or MsgCallback.
+func (c *UDPConn) MessageType() int {
+	return c.typ
+}
+
+// SetReadLimit sets the max size of a message, 0 means the default 4MB.
+// The connection is closed with ErrMessageTooLarge if a larger reliable message is received.
+func (c *UDPConn) SetReadLimit(limit int64) {
+	if limit <= 0 {
+		limit = udpDefReadLimit
+	}
+	c.lock.Lock()
+	c.readLimit = limit
+	c.lock.Unlock()
+}
+
+// Write writes b as a reliable message.
+func (c *UDPConn) Write(b []byte) (int, error) {
+	if err := c.WriteMessage(ReliableChannel, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *UDPConn) wrapper() interface{} {
+	return UDPMessage{}
+}
+
+// WriteMessage writes data as a message on the channel, 0 means ReliableChannel.
+// An unreliable message larger than a packet is sent on the reliable channel.
+// It blocks if the send queue of the reliable channel is full.
+func (c *UDPConn) WriteMessage(channel int, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	if c.err != nil {
+		return c.err
+	}
+
+	now := time.Now()
+	if channel == UnreliableChannel && len(data) <= udpMaxUnreliable {
+		b := udpPacket(udpUnreliable, c.conv, len(data))
+		copy(b[udpHeaderSize:], data)
+		c.send(b, now)
+		return nil
+	}
+
+	count := (len(data) + udpMSS - 1) / udpMSS
+	if count == 0 {
+		count = 1
+	}
+	if count > udpMaxFragments {
+		return ErrMessageTooLarge
+	}
+	for len(c.sndQueue) > 0 && len(c.sndQueue)+count > udpSendQueueSize {
+		if !c.writeDeadline.IsZero() && !now.Before(c.writeDeadline) {
+			return udpTimeoutError{}
+		}
+		c.wait(c.writable, c.writeDeadline)
+		if c.closed {
+			return io.ErrClosedPipe
+		}
+		if c.err != nil {
+			return c.err
+		}
+		now = time.Now()
+	}
+
+	data = append([]byte(nil), data...)
+	for i := 0; i < count; i++ {
+		size := len(data)
+		if size > udpMSS {
+			size = udpMSS
+		}
+		c.sndQueue = append(c.sndQueue, &udpSegment{frg: uint16(count - i - 1), data: data[:size]})
+		data = data[size:]
+	}
+	notify(c.wakeup)
+	return nil
+}
+
+// Close closes the connection after the reliable messages are sent, within 5 seconds.
+// Any blocked Read or Write operations will be unblocked and return errors.
+func (c *UDPConn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.closed = true
+	c.linger = time.Now().Add(udpLinger)
+	if c.err != nil {
+		c.finish(c.err)
+	}
+	notify(c.readable)
+	notify(c.writable)
+	notify(c.wakeup)
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *UDPConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the remote network address.
+func (c *UDPConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline sets the read and write deadlines associated with the connection.
+func (c *UDPConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls and any currently-blocked Read call.
+func (c *UDPConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline = t
+	c.lock.Unlock()
+	notify(c.readable)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls and any currently-blocked Write call.
+func (c *UDPConn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.writeDeadline = t
+	c.lock.Unlock()
+	notify(c.writable)
+	return nil
+}
@@ -0,0 +1,73 @@
+package dnet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"time"
+)
+
+// UDPMessage sends Message on Channel of a UDP session, Message is encoded by the codec.
+// The other sessions send Message as is.
+type UDPMessage struct {
+	Channel int // ReliableChannel or UnreliableChannel
+	Message interface{}
+}
+
+type DefUDPCodec struct{}
+
+// 解码
+func (_ DefUDPCodec) Decode(reader io.Reader) (interface{}, error) {
+	if r, ok := reader.(messageReader); ok {
+		return r.ReadMessage()
+	}
+	return nil, fmt.Errorf("dnet:defUDPCodec decode reader is %s, need a message reader", reflect.TypeOf(reader))
+}
+
+// 编码
+func (_ DefUDPCodec) Encode(o interface{}) ([]byte, error) {
+	data, ok := o.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("dnet:defUDPCodec encode interface{} is %s, need type []byte", reflect.TypeOf(o))
+	}
+	return data, nil
+}
+
+type UDPSession struct {
+	*session
+}
+
+// Channel returns the channel of the message being read if the session is built on a *UDPConn,
+// call it in MsgCallback, or NetConn().(*UDPConn).MessageType() with the session given to MsgCallback.
+func (this *UDPSession) Channel() int {
+	if c, ok := this.conn.(*UDPConn); ok {
+		return c.MessageType()
+	}
+	return 0
+}
+
+// RTT returns the smoothed round trip time if the session is built on a *UDPConn
+func (this *UDPSession) RTT() time.Duration {
+	if c, ok := this.conn.(*UDPConn); ok {
+		return c.RTT()
+	}
+	return 0
+}
+
+// NewUDPSession return an initialized *UDPSession
+func NewUDPSession(conn net.Conn, options ...Option) *UDPSession {
+	op := loadOptions(options...)
+	if op.MsgCallback == nil {
+		// need message callback
+		panic(ErrNilMsgCallBack)
+	}
+	// init default codec
+	if op.Codec == nil {
+		op.Codec = DefUDPCodec{}
+	}
+
+	return &UDPSession{
+		session: newSession(conn, op),
+	}
+}
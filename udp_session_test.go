@@ -0,0 +1,261 @@
+package dnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// udpLossyProxy relays the packets between a client and target, dropping the packets by loss rate.
+func udpLossyProxy(t *testing.T, target string, loss float64) (string, func()) {
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := net.DialUDP("udp", nil, targetAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	var client *net.UDPAddr
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := listener.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			client = addr
+			lock.Unlock()
+			if rand.Float64() >= loss {
+				_, _ = upstream.Write(buf[:n])
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := upstream.Read(buf)
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			addr := client
+			lock.Unlock()
+			if addr != nil && rand.Float64() >= loss {
+				_, _ = listener.WriteToUDP(buf[:n], addr)
+			}
+		}
+	}()
+	return listener.LocalAddr().String(), func() {
+		listener.Close()
+		upstream.Close()
+	}
+}
+
+func TestUDPSession(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.LocalAddr().String()
+	l.Close()
+
+	closed := make(chan error, 1)
+	acceptor := NewUDPAcceptor(address)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewUDPSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {
+				channel := session.NetConn().(*UDPConn).MessageType()
+				_ = session.Send(UDPMessage{Channel: channel, Message: message})
+			}),
+			WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	proxy, stop := udpLossyProxy(t, address, 0.1)
+	defer stop()
+
+	conn, err := DialUDP(proxy, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reliable := make(chan []byte, 1024)
+	unreliable := make(chan []byte, 1024)
+	session := NewUDPSession(conn, WithBlockSend(true), WithMessageCallback(func(session Session, message interface{}) {
+		if session.NetConn().(*UDPConn).MessageType() == UnreliableChannel {
+			unreliable <- message.([]byte)
+		} else {
+			reliable <- message.([]byte)
+		}
+	}))
+
+	// 丢包时可靠有序，包括分片的消息
+	const count = 300
+	message := func(i int) []byte {
+		size := 8
+		if i%10 == 0 {
+			size = 5000
+		}
+		msg := bytes.Repeat([]byte{byte(i)}, size)
+		binary.BigEndian.PutUint32(msg, uint32(i))
+		return msg
+	}
+	for i := 0; i < count; i++ {
+		if err := session.Send(message(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-reliable:
+			if !bytes.Equal(msg, message(i)) {
+				t.Fatalf("message %d: recv %d bytes, index %d", i, len(msg), binary.BigEndian.Uint32(msg))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message %d timeout", i)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := session.Send(UDPMessage{Channel: UnreliableChannel, Message: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-unreliable:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unreliable message timeout")
+	}
+	if rtt := session.RTT(); rtt <= 0 {
+		t.Fatalf("rtt %v", rtt)
+	}
+
+	session.Close(nil)
+	select {
+	case reason := <-closed:
+		if reason != io.EOF {
+			t.Fatalf("close reason %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+}
+
+func TestUDPAcceptorSpoofedSyn(t *testing.T) {
+	acceptor := NewUDPAcceptor("127.0.0.1:0")
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewUDPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := DialUDP(acceptor.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := make(chan []byte, 2)
+	session := NewUDPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		recv <- message.([]byte)
+	}))
+	defer session.Close(nil)
+	echo := func(b byte) {
+		if err := session.Send([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-recv:
+			if len(msg) != 1 || msg[0] != b {
+				t.Fatalf("echo %v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("echo timeout")
+		}
+	}
+	echo(1)
+
+	// 同一地址上伪造的 SYN 和错误的 cookie 不建立连接，也不替换已有的连接
+	c := conn.(*UDPConn)
+	_ = c.output(udpPacket(udpSyn, c.conv+1, udpCookieSize))
+	_ = c.output(udpPacket(udpCookie, c.conv+1, udpCookieSize))
+	spoofer, err := net.DialUDP("udp", nil, acceptor.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+	_, _ = spoofer.Write(udpPacket(udpSyn, 1, udpCookieSize))
+	time.Sleep(100 * time.Millisecond)
+
+	acceptor.lock.Lock()
+	n := len(acceptor.conns)
+	acceptor.lock.Unlock()
+	if n != 1 {
+		t.Fatalf("connections %d, want 1", n)
+	}
+	echo(2)
+}
+
+func TestUDPAcceptorMaxConnections(t *testing.T) {
+	acceptor := NewUDPAcceptor("127.0.0.1:0", WithMaxConnections(1, 0))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewUDPSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := DialUDP(acceptor.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 超出上限的握手被拒绝，不创建连接
+	start := time.Now()
+	if _, err := DialUDP(acceptor.Addr().String(), 5*time.Second); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("dial %v, want ECONNREFUSED", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("refused after %v", d)
+	}
+	acceptor.lock.Lock()
+	n := len(acceptor.conns)
+	acceptor.lock.Unlock()
+	if n != 1 || acceptor.Connections() != 1 {
+		t.Fatalf("connections %d %d, want 1", n, acceptor.Connections())
+	}
+}
+
+func TestUDPConnReadLimit(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	for _, size := range []int{udpMSS, 0} {
+		c := newUDPConn(1, addr, addr, func(b []byte) error { return nil }, nil)
+		// 对端只发送未结束的分片，超出默认长度后断开
+		for seq := uint32(0); seq <= udpDefReadLimit/udpMSS+1; seq++ {
+			b := udpPacket(udpData, 1, 6+size)
+			binary.BigEndian.PutUint32(b[udpHeaderSize:], seq)
+			binary.BigEndian.PutUint16(b[udpHeaderSize+4:], 1)
+			c.input(b)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != ErrMessageTooLarge {
+			t.Fatalf("fragment size %d, read %v", size, err)
+		}
+		_ = c.Close()
+	}
+}
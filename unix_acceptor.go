@@ -0,0 +1,213 @@
+package dnet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// UnixAcceptor serves the unix domain socket at a path.
+// TCPSession and its codecs work over the accepted connections unchanged.
+// The IP options are not applied, and TLS is not served.
+type UnixAcceptor struct {
+	path     string
+	opts     *AcceptorOptions
+	listener net.Listener
+	lock     sync.Mutex
+	tracker  *connTracker
+	started  int32
+}
+
+// NewUnixAcceptor returns a new instance of UnixAcceptor.
+// The path starting with '@' is an abstract socket on linux, it has no socket file.
+func NewUnixAcceptor(path string, options ...AcceptorOption) *UnixAcceptor {
+	opts := loadAcceptorOptions(options...)
+	// 没有 ip
+	opts.IPFilter = nil
+	opts.MaxConnectionsPerIP = 0
+	return &UnixAcceptor{path: path, opts: opts, tracker: newConnTracker(opts)}
+}
+
+// ServeUnix listen and serve unix path with AcceptorHandler
+func ServeUnix(path string, handler AcceptorHandler) error {
+	return NewUnixAcceptor(path).Serve(handler)
+}
+
+// ServeUnixFunc listen and serve unix path with AcceptorHandlerFunc
+func ServeUnixFunc(path string, handler AcceptorHandlerFunc) error {
+	return NewUnixAcceptor(path).ServeFunc(handler)
+}
+
+// Serve listens and serve in the specified path.
+// A stale socket file left by a crashed process is removed, it fails if the path is in use.
+// The socket file is removed when the acceptor is stopped.
+func (this *UnixAcceptor) Serve(handler AcceptorHandler) error {
+	if handler == nil {
+		return errors.New("dnet:Serve handler is nil. ")
+	}
+
+	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
+		return errors.New("dnet:Serve acceptor is already started. ")
+	}
+
+	if err := removeStaleSocket(this.path); err != nil {
+		atomic.StoreInt32(&this.started, 0)
+		return err
+	}
+
+	listener, err := listenUnix(this.path, this.opts.SocketMode)
+	if err != nil {
+		atomic.StoreInt32(&this.started, 0)
+		return err
+	}
+
+	this.lock.Lock()
+	if atomic.LoadInt32(&this.started) == 0 {
+		// 已经调用 Stop
+		this.lock.Unlock()
+		_ = listener.Close()
+		return io.EOF
+	}
+	this.listener = listener
+	this.lock.Unlock()
+	defer this.Stop()
+
+	return acceptLoop(listener, func(conn net.Conn) {
+		if err := this.tracker.acquire(""); err != nil {
+			go this.tracker.refuse(conn, err)
+			return
+		}
+		go this.tracker.handle(conn, "", handler)
+	})
+}
+
+// ServeFunc listens and serve in the specified path
+func (this *UnixAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
+	return this.Serve(handler)
+}
+
+// Addr returns the addr the acceptor will listen on
+func (this *UnixAcceptor) Addr() net.Addr {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.listener == nil {
+		return nil
+	}
+	return this.listener.Addr()
+}
+
+// Stop stops the acceptor, and removes the socket file
+func (this *UnixAcceptor) Stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if atomic.CompareAndSwapInt32(&this.started, 1, 0) && this.listener != nil {
+		_ = this.listener.Close()
+	}
+}
+
+// Connections returns the number of connections
+func (this *UnixAcceptor) Connections() int {
+	n, _ := this.tracker.count("")
+	return n
+}
+
+// Shutdown stops the acceptor, and closes the sessions built on the accepted connections
+// after their send queues are drained. The sessions still open when ctx is done are force closed.
+// The sessions built on the connections after OnConnection returns are tracked too.
+func (this *UnixAcceptor) Shutdown(ctx context.Context) error {
+	this.Stop()
+	return this.tracker.shutdown(ctx)
+}
+
+// unixListener is the listener whose socket file is renamed to addr, it removes the file when it is closed.
+type unixListener struct {
+	*net.UnixListener
+	addr *net.UnixAddr
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	_ = os.Remove(l.addr.Name)
+	return err
+}
+
+// listenUnix listens at path. If mode is set, the socket file is created in a private directory,
+// set to mode and renamed to path, so it is never accessible with the permission from umask.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 || isAbstractSocket(path) {
+		return net.Listen("unix", path)
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".dnet")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// 文件改名后由 unixListener 删除
+	listener.SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, mode); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+func isAbstractSocket(path string) bool {
+	return len(path) > 0 && path[0] == '@'
+}
+
+// removeStaleSocket removes the socket file at path if no one is listening on it
+func removeStaleSocket(path string) error {
+	if isAbstractSocket(path) {
+		return nil
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode().IsRegular() || fi.IsDir() {
+		return errors.New("dnet:Serve " + path + " is not a socket. ")
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return errors.New("dnet:Serve " + path + " is in use. ")
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		// 如监听者繁忙或没有权限，不能确定没有人在监听
+		return errors.New("dnet:Serve " + path + " may be in use, " + err.Error())
+	}
+	return os.Remove(path)
+}
+
+// DialUnix connects to the unix domain socket at path
+func DialUnix(path string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return dialer.Dial("unix", path)
+}
@@ -0,0 +1,80 @@
+package dnet
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixAcceptor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnet.sock")
+
+	// 进程崩溃留下的 socket 文件
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	acceptor := NewUnixAcceptor(path, WithSocketMode(0600))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode %v", fi.Mode())
+	}
+	if addr := acceptor.Addr(); addr == nil || addr.String() != path {
+		t.Fatalf("acceptor addr %v, want %s", addr, path)
+	}
+	// 私有目录已删除
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("files in dir %d, want 1", len(files))
+	}
+	if err := NewUnixAcceptor(path).ServeFunc(func(conn net.Conn) {}); err == nil {
+		t.Fatal("serve the path in use succeeded")
+	}
+
+	conn, err := DialUnix(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := make(chan []byte, 1)
+	session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		recv <- message.([]byte)
+	}))
+	if err := session.Send([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-recv:
+		if len(msg) != 3 {
+			t.Fatalf("echo %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("echo timeout")
+	}
+	session.Close(nil)
+
+	acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file is not removed, %v", err)
+	}
+}
@@ -1,29 +1,40 @@
 package dnet
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"github.com/gorilla/websocket"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type WSAcceptor struct {
 	address  string
+	opts     *AcceptorOptions
 	handler  *wsHandler
 	listener net.Listener
+	lock     sync.Mutex
 	started  int32
 }
 
 // NewWSAcceptor returns a new instance of WSAcceptor
-func NewWSAcceptor(address string) *WSAcceptor {
+func NewWSAcceptor(address string, options ...AcceptorOption) *WSAcceptor {
+	opts := loadAcceptorOptions(options...)
 	return &WSAcceptor{
 		address: address,
+		opts:    opts,
 		handler: &wsHandler{
+			tracker: newConnTracker(opts),
+			routes:  map[string]AcceptorHandler{},
 			upgrader: &websocket.Upgrader{
+				Subprotocols:      opts.Subprotocols,
+				EnableCompression: opts.EnableCompression,
 				CheckOrigin: func(r *http.Request) bool {
 					// allow all connections by default
 					return true
@@ -45,37 +56,150 @@ func ServeWSFunc(address string, handler AcceptorHandlerFunc) error {
 
 type wsHandler struct {
 	upgrader *websocket.Upgrader
-	handler  AcceptorHandler
+	tracker  *connTracker
+	lock     sync.RWMutex
+	handler  AcceptorHandler            // 默认
+	routes   map[string]AcceptorHandler // 按路径
+}
+
+// route returns the handler of the path
+func (h *wsHandler) route(path string) AcceptorHandler {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if handler, ok := h.routes[path]; ok {
+		return handler
+	}
+	return h.handler
 }
 
 func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler := h.route(r.URL.Path)
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ip := hostIP(r.RemoteAddr)
+	if filter := h.tracker.opts.IPFilter; filter != nil {
+		ip = filter.clientIP(r)
+	}
+	if err := h.tracker.acquire(ip); err != nil {
+		if err == ErrIPDenied || h.tracker.opts.RefuseCallback == nil {
+			code := http.StatusServiceUnavailable
+			if err == ErrIPDenied {
+				code = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if c, uErr := h.upgrader.Upgrade(w, r, nil); uErr == nil {
+			h.tracker.refuse(NewWSConn(c), err)
+		}
+		return
+	}
+
+	if callback := h.tracker.opts.UpgradeCallback; callback != nil {
+		if code := callback(r); code != 0 {
+			h.tracker.release(ip)
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+	}
+
 	c, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		h.tracker.release(ip)
 		log.Printf("dnet:ServeHTTP WSSession Upgrade failed, %s\n", err.Error())
 		return
 	}
-	h.handler.OnConnection(NewWSConn(c))
+	conn := NewWSConn(c)
+	conn.request = r
+	if err = conn.configure(h.tracker.opts.CompressionLevel, h.tracker.opts.MaxMessageSize); err != nil {
+		_ = conn.Close()
+		h.tracker.release(ip)
+		log.Printf("dnet:ServeHTTP WSSession configure failed, %s\n", err.Error())
+		return
+	}
+	h.tracker.handle(conn, ip, handler)
 }
 
-// Serve listens and serve in the specified addr
+// filterListener closes the connections from the denied ips before the TLS handshake and the upgrade.
+// The connections from the trusted proxies are accepted, their clients are checked in ServeHTTP.
+type filterListener struct {
+	net.Listener
+	filter *IPFilter
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(conn.RemoteAddr())
+		if l.filter.Allow(ip) || l.filter.trustedProxy(ip) {
+			return conn, nil
+		}
+		_ = conn.Close()
+	}
+}
+
+// Handle registers the handler for the connections upgraded on the path, such as "/ws".
+// The connections on the other paths go to the handler of Serve.
+func (this *WSAcceptor) Handle(path string, handler AcceptorHandler) {
+	this.handler.lock.Lock()
+	this.handler.routes[path] = handler
+	this.handler.lock.Unlock()
+}
+
+// HandleFunc registers the handler function for the path
+func (this *WSAcceptor) HandleFunc(path string, handler AcceptorHandlerFunc) {
+	this.Handle(path, handler)
+}
+
+// Serve listens and serve in the specified addr.
+// handler may be nil if the paths are registered with Handle, the other paths are not found.
 func (this *WSAcceptor) Serve(handler AcceptorHandler) error {
-	if handler == nil {
+	this.handler.lock.Lock()
+	if handler == nil && len(this.handler.routes) == 0 {
+		this.handler.lock.Unlock()
 		return errors.New("dnet:Serve handler is nil. ")
 	}
 	this.handler.handler = handler
+	this.handler.lock.Unlock()
 
 	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
 		return errors.New("dnet:Serve acceptor is already started. ")
 	}
 
+	config, err := this.opts.loadTLSConfig()
+	if err != nil {
+		atomic.StoreInt32(&this.started, 0)
+		return errors.New("dnet:Serve load TLS certificate failed, " + err.Error())
+	}
+
 	listener, err := net.Listen("tcp", this.address)
 	if err != nil {
 		return errors.New("dnet:Serve net.Listen failed, " + err.Error())
 	}
+	if this.opts.IPFilter != nil {
+		listener = &filterListener{Listener: listener, filter: this.opts.IPFilter}
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	this.lock.Lock()
+	if atomic.LoadInt32(&this.started) == 0 {
+		// 已经调用 Stop
+		this.lock.Unlock()
+		_ = listener.Close()
+		return nil
+	}
 	this.listener = listener
+	this.lock.Unlock()
 	defer this.Stop()
 
-	if err = http.Serve(this.listener, this.handler); err != nil {
+	if err = http.Serve(listener, this.handler); err != nil && atomic.LoadInt32(&this.started) == 1 {
 		log.Printf("dnet:Serve failed, %s\n", err.Error())
 	}
 
@@ -89,22 +213,109 @@ func (this *WSAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
 
 // Addr returns the addr the acceptor will listen on
 func (this *WSAcceptor) Addr() net.Addr {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.listener == nil {
+		return nil
+	}
 	return this.listener.Addr()
 }
 
 // Stop stops the acceptor
 func (this *WSAcceptor) Stop() {
-	if !atomic.CompareAndSwapInt32(&this.started, 1, 0) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if atomic.CompareAndSwapInt32(&this.started, 1, 0) && this.listener != nil {
 		_ = this.listener.Close()
 	}
 }
 
-func DialWS(host string, timeout time.Duration) (net.Conn, error) {
-	u := url.URL{Scheme: "ws", Host: host}
-	websocket.DefaultDialer.HandshakeTimeout = timeout
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+// Connections returns the number of connections
+func (this *WSAcceptor) Connections() int {
+	n, _ := this.handler.tracker.count("")
+	return n
+}
+
+// ConnectionsByIP returns the number of connections from ip
+func (this *WSAcceptor) ConnectionsByIP(ip string) int {
+	_, n := this.handler.tracker.count(ip)
+	return n
+}
+
+// Shutdown stops the acceptor, and closes the sessions built on the accepted connections
+// after their send queues are drained. The sessions still open when ctx is done are force closed.
+// The sessions built on the connections after OnConnection returns are tracked too.
+func (this *WSAcceptor) Shutdown(ctx context.Context) error {
+	this.Stop()
+	return this.handler.tracker.shutdown(ctx)
+}
+
+// WSDialer contains options for connecting to WebSocket server.
+// The zero value dials ws without TLS.
+type WSDialer struct {
+	// wss is used if it is set
+	TLSConfig *tls.Config
+
+	// the headers of the handshake request, such as Origin and Cookie
+	Header http.Header
+
+	// the path of the url, such as "/ws"
+	Path string
+
+	// the query of the url, such as a token
+	Query url.Values
+
+	// the deadline for the handshake, 0 means no timeout
+	HandshakeTimeout time.Duration
+
+	// the subprotocols requested, the one selected by the server is WSConn.Subprotocol
+	Subprotocols []string
+
+	// negotiate permessage-deflate with the server if EnableCompression is true,
+	// CompressionLevel is the flate level of the messages written, 0 means default.
+	EnableCompression bool
+	CompressionLevel  int
+
+	// the max size of a message read, 0 means no limit
+	MaxMessageSize int64
+}
+
+// Dial connects to the host
+func (d *WSDialer) Dial(host string) (net.Conn, error) {
+	u := url.URL{Scheme: "ws", Host: host, Path: d.Path, RawQuery: d.Query.Encode()}
+	if d.TLSConfig != nil {
+		u.Scheme = "wss"
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   d.TLSConfig,
+		HandshakeTimeout:  d.HandshakeTimeout,
+		Subprotocols:      d.Subprotocols,
+		EnableCompression: d.EnableCompression,
+	}
+	c, _, err := dialer.Dial(u.String(), d.Header)
 	if err != nil {
 		return nil, err
 	}
-	return NewWSConn(conn), nil
+	conn := NewWSConn(c)
+	if err = conn.configure(d.CompressionLevel, d.MaxMessageSize); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func DialWS(host string, timeout time.Duration) (net.Conn, error) {
+	dialer := &WSDialer{HandshakeTimeout: timeout}
+	return dialer.Dial(host)
+}
+
+// DialWSS connects to the host with wss
+func DialWSS(host string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	dialer := &WSDialer{TLSConfig: config, HandshakeTimeout: timeout}
+	return dialer.Dial(host)
 }
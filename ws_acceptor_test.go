@@ -0,0 +1,110 @@
+package dnet
+
+import (
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWSAcceptorMaxConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	acceptor := NewWSAcceptor(address, WithMaxConnections(0, 1))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := DialWS(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 同一 ip 超出上限，升级前返回 503
+	_, resp, err := websocket.DefaultDialer.Dial("ws://"+address, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial over limit %v %v", resp, err)
+	}
+	if n := acceptor.Connections(); n != 1 {
+		t.Fatalf("connections %d, want 1", n)
+	}
+}
+
+func TestWSAcceptorUpgrade(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	acceptor := NewWSAcceptor(address, WithUpgradeCallback(func(r *http.Request) int {
+		if r.URL.Query().Get("token") != "ok" {
+			return http.StatusUnauthorized
+		}
+		return 0
+	}))
+	paths := make(chan string, 1)
+	acceptor.HandleFunc("/chat", func(conn net.Conn) {
+		session := NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+		cookie, err := session.Request().Cookie("user")
+		if err != nil {
+			paths <- err.Error()
+			return
+		}
+		paths <- "/chat " + cookie.Value
+	})
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		paths <- conn.(*WSConn).Request().URL.Path
+		conn.Close()
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		path  string
+		token string
+		want  string
+	}{
+		{"/chat", "ok", "/chat dnet"},
+		{"/game", "ok", "/game"},
+		{"/chat", "bad", ""},
+	}
+	for _, tt := range tests {
+		dialer := &WSDialer{
+			Header: http.Header{"Cookie": []string{"user=dnet"}},
+			Path:   tt.path,
+			Query:  url.Values{"token": []string{tt.token}},
+		}
+		conn, err := dialer.Dial(address)
+		if tt.want == "" {
+			if err == nil {
+				conn.Close()
+				t.Fatalf("%s %s: upgrade succeeded", tt.path, tt.token)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case path := <-paths:
+			if path != tt.want {
+				t.Fatalf("handled %q, want %q", path, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("handle timeout")
+		}
+		conn.Close()
+	}
+}
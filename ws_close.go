@@ -0,0 +1,82 @@
+package dnet
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"time"
+	"unicode/utf8"
+)
+
+// The close codes defined in RFC 6455, section 11.7.
+const (
+	CloseNormalClosure     = websocket.CloseNormalClosure
+	CloseGoingAway         = websocket.CloseGoingAway
+	CloseUnsupportedData   = websocket.CloseUnsupportedData
+	ClosePolicyViolation   = websocket.ClosePolicyViolation
+	CloseMessageTooBig     = websocket.CloseMessageTooBig
+	CloseInternalServerErr = websocket.CloseInternalServerErr
+)
+
+// the max length of the close text, a control frame carries 125 bytes at most
+const maxCloseTextLen = 123
+
+// the deadline for writing the close frame
+const closeFrameTimeout = time.Second
+
+// WSCloseError is the reason of a WebSocket session closed by the close frame of the peer.
+type WSCloseError struct {
+	Code int
+	Text string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("dnet: websocket closed by peer, code %d, %s", e.Code, e.Text)
+}
+
+// closeWriter is implemented by the connections with close frames, such as WSConn.
+type closeWriter interface {
+	WriteClose(code int, text string) error
+}
+
+// DefWSCloseCode maps the reason of Close to the close code and text sent to the peer.
+func DefWSCloseCode(reason error) (code int, text string) {
+	switch reason {
+	case nil:
+		return CloseNormalClosure, ""
+	case ErrAcceptorShutdown, ErrShutdownTimeout, ErrHeartbeatTimeout, ErrReadTimeout:
+		return CloseGoingAway, reason.Error()
+	case ErrRateLimited, ErrSlowConsumer:
+		return ClosePolicyViolation, reason.Error()
+	case ErrMessageTooLarge:
+		return CloseMessageTooBig, reason.Error()
+	default:
+		return CloseInternalServerErr, reason.Error()
+	}
+}
+
+// writeClose sends the close frame for reason, unless the session is closed by the peer.
+func (this *session) writeClose(reason error) {
+	w, ok := this.conn.(closeWriter)
+	if !ok {
+		return
+	}
+	if _, ok := reason.(*WSCloseError); ok {
+		// 对端关闭，已回复
+		return
+	}
+
+	mapper := this.opts.WSCloseCode
+	if mapper == nil {
+		mapper = DefWSCloseCode
+	}
+	code, text := mapper(reason)
+	if len(text) > maxCloseTextLen {
+		// 按字符截断
+		n := maxCloseTextLen
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		text = text[:n]
+	}
+	_ = w.WriteClose(code, text)
+}
@@ -1,18 +1,24 @@
 package dnet
 
 import (
+	"crypto/tls"
 	"github.com/gorilla/websocket"
 	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"time"
 )
 
 // WSConn is an adapter to t.Conn, which implements all t.Conn
 // interface base on *websocket.Conn
 type WSConn struct {
-	conn   *websocket.Conn
-	typ    int // message type
-	reader io.Reader
+	conn      *websocket.Conn
+	typ       int // message type
+	reader    io.Reader
+	request   *http.Request // 升级的请求，只在服务端
+	readLimit int64
+	slot      *connSlot // 接收此连接的 acceptor 的计数
 }
 
 // NewWSConn return an initialized *WSConn
@@ -20,6 +26,12 @@ func NewWSConn(conn *websocket.Conn) *WSConn {
 	return &WSConn{conn: conn}
 }
 
+// Request returns the upgraded http request, so the headers, query and cookies are available.
+// It is nil for the connections from DialWS.
+func (c *WSConn) Request() *http.Request {
+	return c.request
+}
+
 // Read reads data from the connection.
 // Read can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetReadDeadline.
@@ -28,7 +40,7 @@ reRead:
 	if c.reader == nil {
 		t, r, err := c.conn.NextReader()
 		if err != nil {
-			return 0, err
+			return 0, wsError(err)
 		}
 		c.typ = t
 		c.reader = r
@@ -44,17 +56,88 @@ reRead:
 			if err == io.EOF {
 				c.reader = nil
 				err = nil
-				if off == 0 {
+				// 压缩的 reader 可能在返回最后的数据时同时返回 io.EOF
+				if off+n == 0 {
 					goto reRead
 				}
 			}
-			return off + n, err
+			return off + n, wsError(err)
 		}
 		off += n
 	}
 	return off, nil
 }
 
+// ReadMessage reads the rest of the current message, or the next message.
+// It returns ErrMessageTooLarge if the message is larger than the read limit.
+func (c *WSConn) ReadMessage() ([]byte, error) {
+	r := c.reader
+	c.reader = nil
+	if r == nil {
+		t, next, err := c.conn.NextReader()
+		if err != nil {
+			return nil, wsError(err)
+		}
+		c.typ = t
+		r = next
+	}
+
+	if c.readLimit > 0 {
+		// 限制解压后的大小
+		r = io.LimitReader(r, c.readLimit+1)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, wsError(err)
+	}
+	if c.readLimit > 0 && int64(len(data)) > c.readLimit {
+		return nil, ErrMessageTooLarge
+	}
+	return data, nil
+}
+
+// SetReadLimit sets the max size of a message, 0 means no limit.
+// Reading a larger message fails with ErrMessageTooLarge.
+func (c *WSConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+	c.conn.SetReadLimit(limit)
+}
+
+// SetCompressionLevel sets the flate compression level for the messages written,
+// it works if permessage-deflate is negotiated.
+func (c *WSConn) SetCompressionLevel(level int) error {
+	return c.conn.SetCompressionLevel(level)
+}
+
+// configure sets the compression level and the read limit, 0 means default.
+func (c *WSConn) configure(level int, limit int64) error {
+	if level != 0 {
+		if err := c.SetCompressionLevel(level); err != nil {
+			return err
+		}
+	}
+	if limit > 0 {
+		c.SetReadLimit(limit)
+	}
+	return nil
+}
+
+// WriteClose sends a close frame with the code and text.
+func (c *WSConn) WriteClose(code int, text string) error {
+	return c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(closeFrameTimeout))
+}
+
+// wsError maps the errors of websocket
+func wsError(err error) error {
+	if err == websocket.ErrReadLimit {
+		return ErrMessageTooLarge
+	}
+	if e, ok := err.(*websocket.CloseError); ok {
+		return &WSCloseError{Code: e.Code, Text: e.Text}
+	}
+	return err
+}
+
 // Write writes data to the connection.
 // Write can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
@@ -67,10 +150,59 @@ func (c *WSConn) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// WriteMessage writes data as a message of typ, 0 means BinaryMessage.
+func (c *WSConn) WriteMessage(typ int, data []byte) error {
+	if typ == 0 {
+		typ = websocket.BinaryMessage
+	}
+	return c.conn.WriteMessage(typ, data)
+}
+
+func (c *WSConn) wrapper() interface{} {
+	return WSMessage{}
+}
+
+// MessageType returns the type of the message being read, TextMessage or BinaryMessage.
+// Call it in Codec.Decode or MsgCallback.
+func (c *WSConn) MessageType() int {
+	return c.typ
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (c *WSConn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
+// WritePing sends a ping control frame, the peer replies a pong.
+func (c *WSConn) WritePing(deadline time.Time) error {
+	return c.conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+// SetPongHandler sets the handler for the pong control frames.
+// The handler is called in Read.
+func (c *WSConn) SetPongHandler(h func()) {
+	c.conn.SetPongHandler(func(string) error {
+		h()
+		return nil
+	})
+}
+
+// ConnectionState returns the TLS state of a wss connection, it is zero for ws.
+func (c *WSConn) ConnectionState() tls.ConnectionState {
+	if tlsConn, ok := c.conn.UnderlyingConn().(*tls.Conn); ok {
+		return tlsConn.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
 func (c *WSConn) Close() error {
-	return c.conn.Close()
+	err := c.conn.Close()
+	if c.slot != nil {
+		c.slot.release()
+	}
+	return err
 }
 
 // LocalAddr returns the local network address.
@@ -3,15 +3,68 @@ package dnet
 import (
 	"bytes"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"io"
 	"net"
+	"net/http"
 	"reflect"
 )
 
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+)
+
+// WSMessage sends Message in the frame of Type, Message is encoded by the codec.
+// The other sessions send Message as is.
+type WSMessage struct {
+	Type    int // TextMessage or BinaryMessage
+	Message interface{}
+}
+
+// unwrapMessage returns the message wrapped by WSMessage or UDPMessage, and its frame type or channel, 0 means default.
+func unwrapMessage(o interface{}) (interface{}, int) {
+	switch m := o.(type) {
+	case WSMessage:
+		return m.Message, m.Type
+	case UDPMessage:
+		return m.Message, m.Channel
+	}
+	return o, 0
+}
+
+// messageWriter is implemented by the message based connections, such as WSConn.
+// WriteMessage writes data as a message of typ, 0 means default.
+// wrapper returns the wrapper whose frame type or channel is the typ of WriteMessage, such as WSMessage{}.
+type messageWriter interface {
+	WriteMessage(typ int, data []byte) error
+	wrapper() interface{}
+}
+
+// messageType returns the frame type or channel of o if writer accepts the wrapper of o, 0 means default.
+// UDP 通道与 WebSocket 帧类型的取值重叠，其他连接的包装只取出消息
+func messageType(o interface{}, writer messageWriter) int {
+	if writer == nil || reflect.TypeOf(o) != reflect.TypeOf(writer.wrapper()) {
+		return 0
+	}
+	_, typ := unwrapMessage(o)
+	return typ
+}
+
 type DefWsCodec struct{}
 
+// messageReader is implemented by the message based connections, such as WSConn.
+// ReadMessage returns a whole message.
+type messageReader interface {
+	ReadMessage() ([]byte, error)
+}
+
 //解码
 func (_ DefWsCodec) Decode(reader io.Reader) (interface{}, error) {
+	if r, ok := reader.(messageReader); ok {
+		return r.ReadMessage()
+	}
+
 	buff := new(bytes.Buffer)
 	_, err := buff.ReadFrom(reader)
 	if err != nil {
@@ -33,6 +86,31 @@ type WSSession struct {
 	*session
 }
 
+// Request returns the upgraded http request if the session is built on a *WSConn accepted by WSAcceptor
+func (this *WSSession) Request() *http.Request {
+	if c, ok := this.conn.(*WSConn); ok {
+		return c.Request()
+	}
+	return nil
+}
+
+// MessageType returns the type of the message being read if the session is built on a *WSConn,
+// call it in MsgCallback, or NetConn().(*WSConn).MessageType() with the session given to MsgCallback.
+func (this *WSSession) MessageType() int {
+	if c, ok := this.conn.(*WSConn); ok {
+		return c.MessageType()
+	}
+	return 0
+}
+
+// Subprotocol returns the negotiated subprotocol if the session is built on a *WSConn
+func (this *WSSession) Subprotocol() string {
+	if c, ok := this.conn.(*WSConn); ok {
+		return c.Subprotocol()
+	}
+	return ""
+}
+
 // NewWSSession return an initialized *WSSession
 func NewWSSession(conn net.Conn, options ...Option) *WSSession {
 	op := loadOptions(options...)
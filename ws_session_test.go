@@ -1,9 +1,12 @@
 package dnet
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -58,3 +61,191 @@ func TestNewWSSession(t *testing.T) {
 	fmt.Println(session.Send([]byte{1, 2, 3, 4}))
 	time.Sleep(time.Second)
 }
+
+func TestWSSessionMessageType(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	acceptor := NewWSAcceptor(address, WithSubprotocols("proto", "json"))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			// 按收到的类型回复
+			typ := session.NetConn().(*WSConn).MessageType()
+			_ = session.Send(WSMessage{Type: typ, Message: message})
+		}))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dialer := &WSDialer{Subprotocols: []string{"json"}}
+	conn, err := dialer.Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	type frame struct {
+		typ  int
+		data string
+	}
+	recv := make(chan frame, 3)
+	session := NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		recv <- frame{session.NetConn().(*WSConn).MessageType(), string(message.([]byte))}
+	}))
+	if p := session.Subprotocol(); p != "json" {
+		t.Fatalf("subprotocol %q, want json", p)
+	}
+
+	if err := session.Send(WSMessage{Type: TextMessage, Message: []byte("text")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Send([]byte("binary")); err != nil {
+		t.Fatal(err)
+	}
+	// UDP 通道不是帧类型
+	if err := session.Send(UDPMessage{Channel: ReliableChannel, Message: []byte("udp")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []frame{{TextMessage, "text"}, {BinaryMessage, "binary"}, {BinaryMessage, "udp"}} {
+		select {
+		case f := <-recv:
+			if f != want {
+				t.Fatalf("recv %v, want %v", f, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("recv timeout")
+		}
+	}
+}
+
+func TestWSSessionCompression(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	closed := make(chan error, 1)
+	acceptor := NewWSAcceptor(address, WithCompression(9), WithMaxMessageSize(1024))
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		session := NewWSSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {}),
+			WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+		_ = session.Send(bytes.Repeat([]byte("dnet"), 1<<16))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dialer := &WSDialer{EnableCompression: true, CompressionLevel: 1}
+	conn, err := dialer.Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	recv := make(chan []byte, 1)
+	session := NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		recv <- message.([]byte)
+	}))
+	select {
+	case msg := <-recv:
+		if len(msg) != 4<<16 {
+			t.Fatalf("recv %d bytes", len(msg))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("recv timeout")
+	}
+
+	// 超过服务端的消息大小限制
+	if err := session.Send(make([]byte, 2048)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-closed:
+		if reason != ErrMessageTooLarge {
+			t.Fatalf("close reason %v, want %v", reason, ErrMessageTooLarge)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+}
+
+func TestWSConnReadLastData(t *testing.T) {
+	// 压缩的 reader 返回最后的数据时同时返回 io.EOF
+	conn := &WSConn{reader: iotest.DataErrReader(strings.NewReader("dnet"))}
+	buf := make([]byte, 8)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "dnet" {
+		t.Fatalf("read %q %v", buf[:n], err)
+	}
+	if conn.reader != nil {
+		t.Fatal("reader of the read message is kept")
+	}
+}
+
+func TestWSSessionClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	closed := make(chan error, 1)
+	acceptor := NewWSAcceptor(address)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		NewWSSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {
+				session.Close(ErrRateLimited)
+			}),
+			WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+	})
+	defer acceptor.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 服务端关闭
+	conn, err := DialWS(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerClosed := make(chan error, 1)
+	session := NewWSSession(conn,
+		WithMessageCallback(func(session Session, message interface{}) {}),
+		WithCloseCallback(func(session Session, reason error) { peerClosed <- reason }))
+	if err := session.Send([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-peerClosed:
+		if e, ok := reason.(*WSCloseError); !ok || e.Code != ClosePolicyViolation || e.Text != ErrRateLimited.Error() {
+			t.Fatalf("close reason %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+	<-closed
+
+	// 客户端关闭，自定义关闭码
+	conn, err = DialWS(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session = NewWSSession(conn,
+		WithMessageCallback(func(session Session, message interface{}) {}),
+		WithWSCloseCode(func(reason error) (int, string) { return 4000, "bye" }))
+	time.Sleep(100 * time.Millisecond)
+	session.Close(nil)
+	select {
+	case reason := <-closed:
+		if e, ok := reason.(*WSCloseError); !ok || e.Code != 4000 || e.Text != "bye" {
+			t.Fatalf("close reason %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+}